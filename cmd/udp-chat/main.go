@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+const usage = `usage: udp-chat <command> [args]
+
+commands:
+  replay <capture>  replay a server capture against a fresh in-process server and diff the result
+`
+
+func main() {
+	subcommands := map[string]func([]string) error{"replay": runReplay}
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	run, ok := subcommands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command \"%s\"\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err := run(os.Args[2:]); err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis/v8"
+	"github.com/hirotachi/udp-cli-chat/pkg/server"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"log/slog"
+	"os"
+)
+
+const replayUsage = `usage: udp-chat replay [-timeout duration] [-room name] [-retention policy] [-v] <capture>
+
+sends the inbound packets of a capture recorded with "udp-server -capture" to a fresh in-process server
+one at a time, prints the resulting chat state, the state lines and the outbound packets that differ
+from the capture. exits with status 1 when the replay diverges.
+`
+
+// runReplay replays a capture file against an in-process server backed by a temporary redis db.
+func runReplay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	timeout := flags.Duration("timeout", server.DefaultReplayTimeout, "wait for each replayed packet to be handled")
+	roomName := flags.String("room", server.DefaultRoomName, "name of the replayed room")
+	retention := flags.String("retention", server.RetainUnlimited, "history retention of the replayed room")
+	verbose := flags.Bool("v", false, "log every packet handled by the replay server")
+	flags.Usage = func() { fmt.Fprint(os.Stderr, replayUsage) }
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("could not open capture: %s", err)
+	}
+	records, err := utils.ReadCapture(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	room := server.NewRoom(*roomName)
+	if room.Retention, err = server.ParseRetention(*retention); err != nil {
+		return err
+	}
+	mr, err := miniredis.Run()
+	if err != nil {
+		return fmt.Errorf("error creating redis db: %s", err)
+	}
+	defer mr.Close()
+	s, err := server.NewServer("127.0.0.1:0", redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	if err != nil {
+		return err
+	}
+	s.Room = room
+	level := slog.LevelWarn
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	if s.Logger, err = server.NewLogger(os.Stderr, server.TextLogFormat, level); err != nil {
+		return err
+	}
+
+	result, err := server.Replay(s, records, *timeout)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("replayed %d packets\n\n", result.Packets)
+	for _, line := range result.State {
+		fmt.Println(line)
+	}
+	if !result.Diverges() {
+		fmt.Println("\nreplay matches the capture")
+		return nil
+	}
+	if len(result.StateDiff) != 0 {
+		fmt.Println("\nreplay ends in another state than the capture:")
+		for _, line := range result.StateDiff {
+			fmt.Println(line)
+		}
+	}
+	if len(result.Diff) != 0 {
+		fmt.Println("\nreplay answers differently than the capture:")
+		for _, line := range result.Diff {
+			fmt.Println(line)
+		}
+	}
+	return fmt.Errorf("replay diverges from the capture")
+}
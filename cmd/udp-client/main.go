@@ -1,9 +1,24 @@
 package main
 
-import "github.com/hirotachi/udp-cli-chat/pkg/client"
+import (
+	"flag"
+	"github.com/hirotachi/udp-cli-chat/pkg/client"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+)
 
 func main() {
-	udpClient, err := client.NewUDPClient()
+	capturePath := flag.String("capture", "", "file recording every packet exchanged with the server, empty to disable")
+	flag.Parse()
+
+	var capture *utils.Capture
+	if *capturePath != "" {
+		var err error
+		if capture, err = utils.CreateCapture(*capturePath); err != nil {
+			panic(err)
+		}
+		defer capture.Close()
+	}
+	udpClient, err := client.NewUDPClient(capture)
 	if err != nil {
 		panic(err)
 	}
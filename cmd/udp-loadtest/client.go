@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/server"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loadPrefix marks messages sent by the load test, followed by the send time in unix nanoseconds.
+const loadPrefix = "load:"
+
+// SimClient is a client speaking the real protocol that measures how long its broadcasts take to come back.
+type SimClient struct {
+	conn      *net.UDPConn
+	id        string
+	sent      int
+	latencies []time.Duration
+}
+
+// ConnectSimClient registers a client and waits for its initial payload.
+func ConnectSimClient(address string, username string) (*SimClient, error) {
+	conn, err := utils.GetUDPConnection(address)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to server: %s", err)
+	}
+	if err := utils.WriteToUDPConn(conn, utils.ConnectCommand, &server.LoginInput{Username: username}); err != nil {
+		return nil, fmt.Errorf("could not register %s: %s", username, err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return nil, err
+	}
+	for {
+		bytes, _, err := utils.ReadUDPConn(conn)
+		if err != nil {
+			return nil, fmt.Errorf("%s did not receive its initial payload: %s", username, err)
+		}
+		command, data := utils.ParseCommandAndData(bytes)
+		if command != utils.InitialPayloadCommand {
+			continue
+		}
+		var payload server.InitialPayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return nil, fmt.Errorf("invalid initial payload: %s", err)
+		}
+		if err := conn.SetReadDeadline(time.Time{}); err != nil {
+			return nil, err
+		}
+		return &SimClient{conn: conn, id: payload.AssignedId}, nil
+	}
+}
+
+// Send sends messages at rate per second for duration, padded to size bytes.
+func (c *SimClient) Send(rate float64, duration time.Duration, size int) {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+	deadline := time.After(duration)
+	for {
+		select {
+		case <-deadline:
+			return
+		case now := <-ticker.C:
+			content := loadPrefix + strconv.FormatInt(now.UnixNano(), 10) + " "
+			if len(content) < size {
+				content += strings.Repeat("x", size-len(content))
+			}
+			if err := utils.WriteToUDPConn(c.conn, utils.AddMessageCommand, &server.Message{AuthorID: c.id, Content: content}); err != nil {
+				continue
+			}
+			c.sent += 1
+		}
+	}
+}
+
+// Listen records the latency of every load test broadcast until the client is closed.
+func (c *SimClient) Listen() {
+	for {
+		bytes, _, err := utils.ReadUDPConn(c.conn)
+		if err != nil {
+			return
+		}
+		command, data := utils.ParseCommandAndData(bytes)
+		if command != utils.AddMessageCommand {
+			continue
+		}
+		var message server.Message
+		if err := json.Unmarshal(data, &message); err != nil || !strings.HasPrefix(message.Content, loadPrefix) {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(message.Content, loadPrefix))
+		if len(fields) == 0 {
+			continue
+		}
+		sentAt, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		c.latencies = append(c.latencies, time.Since(time.Unix(0, sentAt)))
+	}
+}
+
+// Close disconnects the client and stops Listen.
+func (c *SimClient) Close() {
+	utils.WriteToUDPConn(c.conn, utils.DisconnectCommand, c.id)
+	c.conn.Close()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis/v8"
+	"github.com/hirotachi/udp-cli-chat/pkg/server"
+	"log"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+func main() {
+	serverAddress := flag.String("addr", "", "UDP address of the server to load, an in-process server backed by miniredis is started when empty")
+	clients := flag.Int("clients", 50, "number of simulated clients")
+	rate := flag.Float64("rate", 1, "messages sent per second by each client")
+	duration := flag.Duration("duration", 10*time.Second, "how long clients send messages")
+	drain := flag.Duration("drain", 2*time.Second, "wait for in-flight messages after sending stops")
+	size := flag.Int("size", 64, "message content size in bytes")
+	flag.Parse()
+	if *clients < 1 || *rate <= 0 {
+		log.Fatalln("clients and rate must be positive")
+	}
+
+	if *serverAddress == "" {
+		address, err := startServer()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		*serverAddress = address
+	}
+
+	simulated := make([]*SimClient, 0, *clients)
+	for i := 0; i < *clients; i++ {
+		client, err := ConnectSimClient(*serverAddress, fmt.Sprintf("load-%04d", i))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		simulated = append(simulated, client)
+	}
+	fmt.Printf("%d clients connected to %s\n", len(simulated), *serverAddress)
+
+	var readers sync.WaitGroup
+	for _, client := range simulated {
+		readers.Add(1)
+		go func(c *SimClient) {
+			defer readers.Done()
+			c.Listen()
+		}(client)
+	}
+	var senders sync.WaitGroup
+	start := time.Now()
+	for _, client := range simulated {
+		senders.Add(1)
+		go func(c *SimClient) {
+			defer senders.Done()
+			c.Send(*rate, *duration, *size)
+		}(client)
+	}
+	senders.Wait()
+	elapsed := time.Since(start)
+	time.Sleep(*drain)
+	for _, client := range simulated {
+		client.Close()
+	}
+	readers.Wait()
+
+	Report(simulated, elapsed)
+}
+
+// startServer runs a server on a random localhost port with a temporary redis db.
+func startServer() (string, error) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		return "", fmt.Errorf("error creating redis db: %s", err)
+	}
+	s, err := server.NewServer("127.0.0.1:0", redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	if err != nil {
+		return "", fmt.Errorf("error creating UDP server: %s", err)
+	}
+	if s.Logger, err = server.NewLogger(os.Stderr, server.TextLogFormat, slog.LevelError); err != nil {
+		return "", err
+	}
+	if err := s.Listen(); err != nil {
+		return "", fmt.Errorf("error listening on UDP server: %s", err)
+	}
+	go s.Run()
+	return s.Addr().String(), nil
+}
+
+// Report prints deliveries, loss and broadcast latency percentiles of all clients.
+func Report(clients []*SimClient, elapsed time.Duration) {
+	sent := 0
+	latencies := make([]time.Duration, 0)
+	for _, client := range clients {
+		sent += client.sent
+		latencies = append(latencies, client.latencies...)
+	}
+	expected := sent * len(clients) // every client receives every message, its own included
+	received := len(latencies)
+	loss := 0.0
+	if expected != 0 {
+		loss = 100 * float64(expected-received) / float64(expected)
+	}
+	fmt.Printf("sent %d messages in %s (%.1f msg/s)\n", sent, elapsed.Round(time.Millisecond), float64(sent)/elapsed.Seconds())
+	fmt.Printf("received %d of %d broadcasts, %.2f%% loss\n", received, expected, loss)
+	if received == 0 {
+		return
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) time.Duration {
+		return latencies[int(p*float64(len(latencies)-1))].Round(time.Microsecond)
+	}
+	fmt.Printf("latency p50 %s  p90 %s  p99 %s  max %s\n", percentile(.5), percentile(.9), percentile(.99), percentile(1))
+}
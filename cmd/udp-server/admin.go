@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/server"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const adminUsage = `usage: udp-server admin [-control path] <command> [args]
+
+commands:
+  users                          list registered users
+  sessions                       list online sessions with addresses and last activity
+  broadcast <message>            send a system notice to all online users
+  kick <name> [reason]           disconnect a user
+  ban <name> [duration] [reason] ban a user account and IP, indefinitely without duration
+  unban <name>                   lift a ban
+  purge                          delete the chat history
+  stats                          dump server stats
+`
+
+// runAdmin sends a single admin command to a running server over its control socket.
+func runAdmin(args []string) error {
+	flags := flag.NewFlagSet("admin", flag.ExitOnError)
+	controlPath := flags.String("control", server.DefaultControlPath, "unix socket path of the running server")
+	flags.Usage = func() { fmt.Fprint(os.Stderr, adminUsage) }
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	request, err := buildControlRequest(flags.Arg(0), flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+	response, err := server.SendControlRequest(*controlPath, request)
+	if err != nil {
+		return err
+	}
+	printControlResponse(request.Command, response)
+	return nil
+}
+
+func buildControlRequest(command string, args []string) (*server.ControlRequest, error) {
+	request := &server.ControlRequest{Command: command}
+	switch command {
+	case server.ListUsersControl, server.ListSessionsControl, server.PurgeControl, server.StatsControl:
+	case server.BroadcastControl:
+		request.Content = strings.Join(args, " ")
+	case server.KickControl, server.BanControl, server.UnbanControl:
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s requires a user name", command)
+		}
+		request.Target, args = args[0], args[1:]
+		if command == server.BanControl && len(args) > 0 && isDuration(args[0]) {
+			request.Duration, args = args[0], args[1:]
+		}
+		request.Reason = strings.Join(args, " ")
+	default:
+		return nil, fmt.Errorf("unknown admin command \"%s\"\n\n%s", command, adminUsage)
+	}
+	return request, nil
+}
+
+func printControlResponse(command string, response *server.ControlResponse) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	switch command {
+	case server.ListUsersControl:
+		fmt.Fprintln(w, "ID\tNAME\tROLE\tONLINE\tMUTED\tBANNED\tLAST SEEN")
+		for _, u := range response.Users {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%t\t%t\t%s\n", u.ID, u.Name, u.Role, u.Online, u.Muted, u.Banned, u.LastSeen.Format("Jan 2 15:04:05"))
+		}
+	case server.ListSessionsControl:
+		fmt.Fprintln(w, "ID\tNAME\tADDRESS\tLAST SEEN")
+		for _, s := range response.Sessions {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.ID, s.Name, s.Address, s.LastSeen.Format("Jan 2 15:04:05"))
+		}
+	case server.StatsControl:
+		stats := response.Stats
+		fmt.Fprintf(w, "uptime\t%s\n", stats.Uptime)
+		fmt.Fprintf(w, "users\t%d\n", stats.Users)
+		fmt.Fprintf(w, "connected\t%d\n", stats.Connected)
+		fmt.Fprintf(w, "history length\t%d\n", stats.HistoryLength)
+		fmt.Fprintf(w, "bans\t%d\n", stats.Bans)
+		fmt.Fprintf(w, "packets in\t%d\n", stats.PacketsIn)
+		fmt.Fprintf(w, "room\t%s\n", stats.Room)
+		fmt.Fprintf(w, "retention\t%s\n", stats.Retention)
+	default:
+		fmt.Fprintln(w, response.Message)
+	}
+}
+
+func isDuration(s string) bool {
+	_, err := time.ParseDuration(s)
+	return err == nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/server"
+	"io"
+	"os"
+)
+
+const exportUsage = `usage: udp-server export [-control path] [-format jsonl|text|markdown] [-o file]
+
+writes the history, users and room of a running server, to stdout unless -o is given.
+text and markdown write a transcript that cannot be imported back.
+`
+
+const importUsage = `usage: udp-server import [-control path] <file>
+
+merges a JSON Lines archive written by export into a running server.
+`
+
+// runExport writes the archive of a running server requested over its control socket.
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	controlPath := flags.String("control", server.DefaultControlPath, "unix socket path of the running server")
+	format := flags.String("format", server.JSONLFormat, "archive format: jsonl, text or markdown")
+	output := flags.String("o", "", "file to write, stdout when empty")
+	flags.Usage = func() { fmt.Fprint(os.Stderr, exportUsage) }
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	response, err := server.SendControlRequest(*controlPath, &server.ControlRequest{Command: server.ExportControl})
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("could not create export file: %s", err)
+		}
+		defer file.Close()
+		w = file
+	}
+	return server.WriteArchive(w, response.Archive, *format)
+}
+
+// runImport sends a JSON Lines archive to a running server over its control socket.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	controlPath := flags.String("control", server.DefaultControlPath, "unix socket path of the running server")
+	flags.Usage = func() { fmt.Fprint(os.Stderr, importUsage) }
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("could not open archive: %s", err)
+	}
+	defer file.Close()
+	archive, err := server.ReadArchiveJSONL(file)
+	if err != nil {
+		return err
+	}
+	response, err := server.SendControlRequest(*controlPath, &server.ControlRequest{Command: server.ImportControl, Archive: archive})
+	if err != nil {
+		return err
+	}
+	fmt.Println(response.Message)
+	return nil
+}
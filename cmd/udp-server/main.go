@@ -2,29 +2,94 @@ package main
 
 import (
 	"context"
+	"flag"
 	"github.com/alicebob/miniredis"
 	"github.com/go-redis/redis/v8"
 	"github.com/hirotachi/udp-cli-chat/pkg/server"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
 	"log"
+	"log/slog"
+	"os"
 )
 
 func main() {
-	// temporary redis server for development
-	mr, err := miniredis.Run()
+	if len(os.Args) > 1 {
+		subcommands := map[string]func([]string) error{"admin": runAdmin, "export": runExport, "import": runImport}
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatalln(err)
+			}
+			return
+		}
+	}
+
+	serverAddress := flag.String("addr", ":5000", "UDP address to listen on")
+	redisAddress := flag.String("redis", "", "redis address, a temporary in-memory db is used when empty")
+	controlPath := flag.String("control", server.DefaultControlPath, "unix socket path for admin commands, empty to disable")
+	roomName := flag.String("room", server.DefaultRoomName, "name of the chat room")
+	retention := flag.String("retention", server.RetainUnlimited, "history retention: unlimited, count:<n> or age:<duration>")
+	ephemeral := flag.Bool("ephemeral", false, "wipe the room history when the last client leaves")
+	metricsAddress := flag.String("metrics", "", "HTTP address serving /metrics and /healthz, empty to disable")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", server.TextLogFormat, "log output format: text or json")
+	capturePath := flag.String("capture", "", "file recording every packet for \"udp-chat replay\", empty to disable")
+	debug := flag.Bool("debug", false, "log every decoded packet, same as -log-level debug")
+	flag.Parse()
+
+	level, err := server.ParseLogLevel(*logLevel)
 	if err != nil {
-		log.Fatalln("error creating redis db: ", err)
+		log.Fatalln(err)
+	}
+	if *debug {
+		level = slog.LevelDebug
 	}
-	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	logger, err := server.NewLogger(os.Stderr, *logFormat, level)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	slog.SetDefault(logger)
+
+	room := server.NewRoom(*roomName)
+	room.Ephemeral = *ephemeral
+	if room.Retention, err = server.ParseRetention(*retention); err != nil {
+		fatal(logger, "invalid retention", err)
+	}
+
+	if *redisAddress == "" {
+		// temporary redis server for development
+		mr, err := miniredis.Run()
+		if err != nil {
+			fatal(logger, "failed to create redis db", err)
+		}
+		*redisAddress = mr.Addr()
+	}
+	redisClient := redis.NewClient(&redis.Options{Addr: *redisAddress})
 	if _, err := redisClient.Ping(context.Background()).Result(); err != nil {
-		log.Fatalln("cannot connect to redis db: ", err)
+		fatal(logger, "failed to connect to redis db", err)
 	}
 
-	serverAddress := ":5000"
-	udpServer, err := server.NewServer(serverAddress, redisClient)
+	udpServer, err := server.NewServer(*serverAddress, redisClient)
 	if err != nil {
-		log.Fatalln("error creating UDP server: ", err)
+		fatal(logger, "failed to create UDP server", err)
+	}
+	udpServer.ControlPath = *controlPath
+	udpServer.Room = room
+	udpServer.MetricsAddr = *metricsAddress
+	udpServer.Logger = logger
+	if *capturePath != "" {
+		capture, err := utils.CreateCapture(*capturePath)
+		if err != nil {
+			fatal(logger, "failed to create capture", err)
+		}
+		defer capture.Close()
+		udpServer.Capture = capture
 	}
 	if err := udpServer.Run(); err != nil {
-		panic(err)
+		fatal(logger, "server stopped", err)
 	}
 }
+
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
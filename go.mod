@@ -1,12 +1,15 @@
 module github.com/hirotachi/udp-cli-chat
 
-go 1.17
+go 1.21
 
 require (
 	github.com/alicebob/miniredis v2.5.0+incompatible
+	github.com/gdamore/tcell/v2 v2.4.1-0.20210905002822-f057f0a857a1
 	github.com/go-redis/redis/v8 v8.11.3
+	github.com/rivo/tview v0.0.0-20210920163636-bb872b4b26a0
 	github.com/rs/xid v1.3.0
 	github.com/stretchr/testify v1.7.0
+	golang.org/x/text v0.3.6
 )
 
 require (
@@ -15,16 +18,13 @@ require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/gdamore/encoding v1.0.0 // indirect
-	github.com/gdamore/tcell/v2 v2.4.1-0.20210905002822-f057f0a857a1 // indirect
 	github.com/gomodule/redigo v1.8.5 // indirect
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/mattn/go-runewidth v0.0.13 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/rivo/tview v0.0.0-20210920163636-bb872b4b26a0 // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
 	github.com/yuin/gopher-lua v0.0.0-20210529063254-f4c35e4016d9 // indirect
 	golang.org/x/sys v0.0.0-20210423082822-04245dca01da // indirect
 	golang.org/x/term v0.0.0-20210220032956-6a3ed077a48d // indirect
-	golang.org/x/text v0.3.6 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
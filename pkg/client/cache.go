@@ -0,0 +1,157 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/server"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+const (
+	CacheLimit        = 1000             // most recent messages kept on disk per server
+	CacheSaveInterval = 10 * time.Second // how often changed messages are written to disk
+)
+
+var unsafePathCharsReg = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// Cache holds the messages of a server room shown on startup before the server answers.
+type Cache struct {
+	Room     string            `json:"room"`
+	SyncedAt time.Time         `json:"synced_at"` // server time of the initial payload the messages were synced at
+	Messages []*server.Message `json:"messages"`
+}
+
+// LastMessageID returns the id of the newest cached message, empty when nothing is cached.
+func (cache *Cache) LastMessageID() string {
+	if cache == nil || len(cache.Messages) == 0 {
+		return ""
+	}
+	return cache.Messages[len(cache.Messages)-1].ID
+}
+
+// cachePath is where messages of a server are cached.
+func cachePath(serverAddress string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	name := unsafePathCharsReg.ReplaceAllString(serverAddress, "_") + ".json"
+	return filepath.Join(dir, "udp-cli-chat", "cache", name), nil
+}
+
+// LoadCache returns the messages cached for server, nil if there are none.
+func LoadCache(serverAddress string) *Cache {
+	path, err := cachePath(serverAddress)
+	if err != nil {
+		return nil
+	}
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var cache Cache
+	if err := json.Unmarshal(bytes, &cache); err != nil {
+		return nil
+	}
+	return &cache
+}
+
+// SaveCache writes the most recent messages of a server to disk.
+func SaveCache(serverAddress string, cache *Cache) error {
+	path, err := cachePath(serverAddress)
+	if err != nil {
+		return fmt.Errorf("could not locate config dir: %s", err)
+	}
+	if len(cache.Messages) > CacheLimit {
+		cache.Messages = cache.Messages[len(cache.Messages)-CacheLimit:]
+	}
+	bytes, err := json.Marshal(cache)
+	if err != nil {
+		return fmt.Errorf("could not marshal message cache: %s", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("could not create cache dir: %s", err)
+	}
+	if err := os.WriteFile(path, bytes, 0600); err != nil {
+		return fmt.Errorf("could not save message cache: %s", err)
+	}
+	return nil
+}
+
+// MergeHistory patches cached messages with the changes sent by the server, dropping deleted ones.
+func MergeHistory(cached []*server.Message, changes []*server.Message, deleted []string) []*server.Message {
+	removed := map[string]bool{}
+	for _, id := range deleted {
+		removed[id] = true
+	}
+	changed := map[string]*server.Message{}
+	for _, message := range changes {
+		changed[message.ID] = message
+	}
+	merged := make([]*server.Message, 0, len(cached)+len(changes))
+	for _, message := range cached {
+		if removed[message.ID] {
+			continue
+		}
+		if update, ok := changed[message.ID]; ok {
+			message = update
+			delete(changed, message.ID)
+		}
+		merged = append(merged, message)
+	}
+	for _, message := range changes {
+		if _, ok := changed[message.ID]; ok && !removed[message.ID] {
+			merged = append(merged, message)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].CreatedAt.Before(merged[j].CreatedAt) })
+	return merged
+}
+
+// ListenToCache shows cached messages until the server history arrives.
+func (board *MessageBoard) ListenToCache() {
+	messages := <-board.Connection.CacheChan
+	board.mu.Lock()
+	defer board.mu.Unlock()
+	if board.historyLoaded {
+		return
+	}
+	board.Store = messages
+	board.Rerender()
+	board.View.ScrollToEnd()
+}
+
+// ListenToCacheSaves writes changed messages to the cache periodically.
+func (board *MessageBoard) ListenToCacheSaves() {
+	ticker := time.NewTicker(CacheSaveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		board.SaveCache()
+	}
+}
+
+// SaveCache writes the loaded messages to disk if they changed since the last save.
+func (board *MessageBoard) SaveCache() {
+	board.mu.Lock()
+	if !board.cacheDirty || !board.historyLoaded {
+		board.mu.Unlock()
+		return
+	}
+	board.cacheDirty = false
+	room, syncedAt := board.Connection.Synced()
+	cache := &Cache{
+		Room:     room,
+		SyncedAt: syncedAt,
+		Messages: make([]*server.Message, len(board.Store)),
+	}
+	copy(cache.Messages, board.Store)
+	err := SaveCache(board.Connection.ServerAddress, cache) // messages are marshaled under the lock
+	board.mu.Unlock()
+	if err != nil {
+		board.Connection.LogError(err)
+	}
+}
@@ -2,40 +2,98 @@ package client
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/hirotachi/udp-cli-chat/pkg/server"
 	"github.com/hirotachi/udp-cli-chat/pkg/utils"
 	"github.com/rivo/tview"
 	"net"
+	"sync"
+	"time"
 )
 
 type Connection struct {
-	AssignID           string
+	ServerAddress      string
 	conn               *net.UDPConn
-	errorsLog          []error
 	MessageChan        chan []byte
 	LogChan            chan error
-	HistoryChan        chan []*server.Message
-	UDPMessagesQueue   chan []byte
+	HistoryChan        chan []*server.Message // initial history, sent again after each reconnection
 	InitialHistory     []*server.Message
 	LocalHistoryLength int
-	isHistoryLoaded    bool
+	UnreadCount        int      // messages received while offline, set by the initial payload
+	cache              *Cache   // messages cached by a previous session
+	delta              bool     // initial history only holds changes since the cache
+	deleted            []string // cached messages deleted since the cache was saved
+	CacheChan          chan []*server.Message
+	received           []bool // initial history orders received
 	MessageDeleteChan  chan string
+	NoticeChan         chan *server.Notice
+	RosterChan         chan []*server.RosterEntry
+	PresenceChan       chan *server.PresenceEvent
+	WhoisChan          chan *server.WhoisInfo
+	TypingChan         chan *server.TypingEvent
+	ReactionChan       chan *ReactionUpdate
+	MentionsChan       chan []*server.Message
+	HistoryPageChan    chan *HistoryPage
+	SearchChan         chan *server.SearchResults
+	page               *HistoryPage   // page being received
+	StateChan          chan State     // state changes, dropped when nobody listens
+	Capture            *utils.Capture // records every packet when set
+	ConnectTimeout     time.Duration
+	SyncTimeout        time.Duration
+	SyncRetries        int
 	app                *tview.Application
+
+	// owned by the run loop, see state.go
+	state        State
+	stateMu      sync.RWMutex // guards state, the identity and sync point below and errorsLog which other goroutines read
+	assignID     string
+	name         string // nickname granted by the server
+	role         string
+	room         string
+	syncedAt     time.Time // server time of the initial payload
+	errorsLog    []error
+	packets      chan []byte
+	readErrors   chan error
+	pageRequests chan string // older history pages requested by the board
+	done         chan struct{}
+	closeOnce    sync.Once
+	timer        *time.Timer
+	username     string   // requested username, sent again when reconnecting
+	attempts     int      // registrations or history requests since the last progress
+	progress     int      // initial history logs received at the last sync timeout
+	early        [][]byte // history logs received before the initial payload
+	queue        [][]byte // live packets received before the initial history is complete
 }
 
 func NewConnection(app *tview.Application) *Connection {
 	return &Connection{
-		errorsLog:          make([]error, 0),
-		MessageChan:        make(chan []byte),
-		LogChan:            make(chan error),
-		HistoryChan:        make(chan []*server.Message),
-		LocalHistoryLength: 0,
-		isHistoryLoaded:    false,
-		InitialHistory:     make([]*server.Message, 0),
-		UDPMessagesQueue:   make(chan []byte),
-		app:                app,
-		MessageDeleteChan:  make(chan string),
+		errorsLog:         make([]error, 0),
+		MessageChan:       make(chan []byte),
+		LogChan:           make(chan error),
+		HistoryChan:       make(chan []*server.Message),
+		InitialHistory:    make([]*server.Message, 0),
+		app:               app,
+		MessageDeleteChan: make(chan string),
+		NoticeChan:        make(chan *server.Notice),
+		RosterChan:        make(chan []*server.RosterEntry),
+		PresenceChan:      make(chan *server.PresenceEvent),
+		WhoisChan:         make(chan *server.WhoisInfo),
+		TypingChan:        make(chan *server.TypingEvent),
+		ReactionChan:      make(chan *ReactionUpdate),
+		MentionsChan:      make(chan []*server.Message),
+		HistoryPageChan:   make(chan *HistoryPage),
+		SearchChan:        make(chan *server.SearchResults),
+		CacheChan:         make(chan []*server.Message),
+		StateChan:         make(chan State, 8),
+		ConnectTimeout:    DefaultConnectTimeout,
+		SyncTimeout:       HistorySyncTimeout,
+		SyncRetries:       HistorySyncRetries,
+		state:             Closed,
+		packets:           make(chan []byte),
+		readErrors:        make(chan error),
+		pageRequests:      make(chan string),
+		done:              make(chan struct{}),
 	}
 }
 
@@ -49,77 +107,147 @@ func (c *Connection) Connect(serverAddress string, username string) error {
 		return fmt.Errorf("failed to dial connection: %s", err)
 	}
 	c.conn = conn
+	c.ServerAddress = serverAddress
+	c.username = username
+	c.cache = LoadCache(serverAddress)
+	if c.cache != nil && len(c.cache.Messages) != 0 {
+		go func(messages []*server.Message) { c.CacheChan <- messages }(c.cache.Messages)
+	}
+	c.SetState(Connecting)
 	c.RegisterClient(username)
+	c.timer = time.NewTimer(c.ConnectTimeout)
 	go c.Listen()
-	go c.ListenToQueue()
+	go c.Run()
 
 	return nil
 }
 
+// Listen reads packets from the connection and hands them to the run loop.
 func (c *Connection) Listen() {
-	defer c.conn.Close()
 	for {
-		c.ReadUDPConnection()
-	}
-}
-
-func (c *Connection) ReadUDPConnection() {
-	bytes, _, err := utils.ReadUDPConn(c.conn)
-	if err != nil {
-		c.LogError(fmt.Errorf("failed to listen to UDP connection: %s", err))
-		return
+		bytes, addr, err := utils.ReadUDPConn(c.conn)
+		if err != nil {
+			select {
+			case c.readErrors <- err:
+			case <-c.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		c.Capture.Record(utils.InboundPacket, addr, bytes)
+		select {
+		case c.packets <- bytes:
+		case <-c.done:
+			return
+		}
 	}
-	c.HandleUDPMessage(bytes)
 }
 
+// HandleUDPMessage applies a packet according to the connection state, packets received while the
+// initial history is loading are queued and applied in order once it is complete.
 func (c *Connection) HandleUDPMessage(msg []byte) {
 	command, data := utils.ParseCommandAndData(msg)
-	if !c.isHistoryLoaded { // while the history is not loaded completely add history messages
+	switch c.State() {
+	case Closed:
+		return
+	case Connecting, Reconnecting:
 		switch command {
 		case utils.InitialPayloadCommand:
 			c.HandleInitialPayload(data)
+		case utils.AddHistoryCommand:
+			c.early = append(c.early, msg)
+		case utils.KickedCommand:
+			c.HandleNotice(data, true)
+		default:
+			c.queue = append(c.queue, msg)
+		}
+	case Syncing:
+		switch command {
+		case utils.InitialPayloadCommand: // duplicated payload of the current sync
 		case utils.AddHistoryCommand:
 			c.AddMessageToHistory(data)
+		case utils.KickedCommand:
+			c.HandleNotice(data, true)
 		default:
-			c.UDPMessagesQueue <- msg
+			c.queue = append(c.queue, msg)
 		}
-	} else {
+	case Live:
 		switch command {
 		case utils.AddMessageCommand:
 			c.MessageChan <- data
 		case utils.DeleteMessageCommand:
 			c.MessageDeleteChan <- string(data)
+		case utils.NoticeCommand:
+			c.HandleNotice(data, false)
+		case utils.KickedCommand:
+			c.HandleNotice(data, true)
+		case utils.PresenceCommand:
+			c.HandlePresence(data)
+		case utils.WhoisCommand:
+			c.HandleWhois(data)
+		case utils.TypingCommand:
+			c.HandleTyping(data)
+		case utils.AddReactionCommand:
+			c.HandleReaction(data, true)
+		case utils.RemoveReactionCommand:
+			c.HandleReaction(data, false)
+		case utils.MentionsCommand:
+			c.HandleMentions(data)
+		case utils.HistoryPageCommand:
+			c.HandleHistoryPage(data)
+		case utils.AddHistoryCommand:
+			c.AddMessageToPage(data)
+		case utils.SearchCommand:
+			c.HandleSearch(data)
+		case utils.ErrorCommand:
+			c.HandleError(data)
+		case utils.InitialPayloadCommand: // late duplicate of the initial payload
 		default:
 			c.LogError(fmt.Errorf("unrecognized command from UDP connection: \"%s\"", command))
 		}
 	}
+}
 
+// Write sends a packet to the server.
+func (c *Connection) Write(command string, data interface{}) error {
+	msg, err := utils.EncodeClientMessage(command, data)
+	if err != nil {
+		return err
+	}
+	if _, err := c.conn.Write(msg); err != nil {
+		return err
+	}
+	c.Capture.Record(utils.OutboundPacket, c.conn.RemoteAddr(), msg)
+	return nil
 }
 
 func (c *Connection) RegisterClient(username string) {
 	loginInput := &server.LoginInput{
-		Username: username,
+		Username:   username,
+		AssignedId: LoadIdentity(c.ServerAddress),
+	}
+	if id := c.AssignID(); id != "" { // reconnecting keeps the account of this session
+		loginInput.AssignedId = id
 	}
-	if err := utils.WriteToUDPConn(c.conn, utils.ConnectCommand, loginInput); err != nil {
+	if c.cache != nil { // only ask for what changed since the cache was saved
+		loginInput.LastMessageID = c.cache.LastMessageID()
+		loginInput.CachedAt = c.cache.SyncedAt
+	}
+	if err := c.Write(utils.ConnectCommand, loginInput); err != nil {
 		c.LogError(fmt.Errorf("could not send connect command to UDP connection: %s", err))
 	}
 }
 
 func (c *Connection) LogError(err error) {
+	c.stateMu.Lock()
 	c.errorsLog = append(c.errorsLog, err)
+	c.stateMu.Unlock()
 	c.LogChan <- err
 }
 
-func (c *Connection) ListenToQueue() {
-	for !c.isHistoryLoaded {
-		//	don't do anything until history has been loaded completely
-	}
-	//	 run queued UDP messages
-	for msg := range c.UDPMessagesQueue {
-		c.HandleUDPMessage(msg)
-	}
-}
-
 func (c *Connection) HandleInitialPayload(data []byte) {
 	var initialPayload server.InitialPayload
 	if err := json.Unmarshal(data, &initialPayload); err != nil {
@@ -131,12 +259,33 @@ func (c *Connection) HandleInitialPayload(data []byte) {
 		return
 	}
 
-	c.AssignID = initialPayload.AssignedId
+	c.setIdentity(initialPayload.AssignedId, initialPayload.Username, initialPayload.Role)
+	c.setSynced(initialPayload.Room, initialPayload.SyncedAt)
+	c.UnreadCount = initialPayload.UnreadCount
+	c.delta = initialPayload.Delta && c.cache != nil && c.cache.Room == initialPayload.Room
+	c.deleted = initialPayload.Deleted
+	if err := SaveIdentity(c.ServerAddress, initialPayload.AssignedId); err != nil {
+		go c.LogError(err)
+	}
+	c.RosterChan <- initialPayload.Roster
+	c.InitialHistory = make([]*server.Message, initialPayload.HistoryLength)
+	c.received = make([]bool, initialPayload.HistoryLength)
+	c.LocalHistoryLength = 0
+	c.attempts = 0
+	c.progress = 0
+	c.SetState(Syncing)
 	if initialPayload.HistoryLength == 0 {
-		c.isHistoryLoaded = true
+		c.early = nil
+		c.CompleteHistory()
 		return
 	}
-	c.InitialHistory = make([]*server.Message, initialPayload.HistoryLength)
+	c.resetTimer(c.SyncTimeout)
+	early := c.early
+	c.early = nil
+	for _, msg := range early { // history logs that overtook the payload
+		_, data := utils.ParseCommandAndData(msg)
+		c.AddMessageToHistory(data)
+	}
 }
 
 func (c *Connection) AddMessageToHistory(data []byte) {
@@ -145,29 +294,246 @@ func (c *Connection) AddMessageToHistory(data []byte) {
 		c.LogError(fmt.Errorf("could not unmarshal history log"))
 		return
 	}
-	c.InitialHistory[historyLog.Order] = historyLog.Message
-	c.LocalHistoryLength += 1
+	if c.State() != Syncing || historyLog.Order < 0 || historyLog.Order >= len(c.InitialHistory) {
+		return
+	}
+	if !c.received[historyLog.Order] { // resent logs may arrive twice
+		c.received[historyLog.Order] = true
+		c.InitialHistory[historyLog.Order] = historyLog.Message
+		c.LocalHistoryLength += 1
+	}
+	if len(c.InitialHistory) == c.LocalHistoryLength {
+		c.CompleteHistory()
+	}
+}
 
-	c.isHistoryLoaded = len(c.InitialHistory) == c.LocalHistoryLength
-	if c.isHistoryLoaded {
-		c.HistoryChan <- c.InitialHistory
-		close(c.UDPMessagesQueue)
-		close(c.HistoryChan)
+// CompleteHistory hands the received history, merged into the cache when only changes were sent, to the board
+// then applies the packets queued while it was loading.
+func (c *Connection) CompleteHistory() {
+	history := make([]*server.Message, 0, len(c.InitialHistory))
+	for _, message := range c.InitialHistory {
+		if message != nil { // deleted before it could be resent, or never received
+			history = append(history, message)
+		}
+	}
+	if c.delta {
+		history = MergeHistory(c.cache.Messages, history, c.deleted)
+	}
+	c.SetState(Live)
+	c.HistoryChan <- history
+	queue := c.queue
+	c.queue = nil
+	for _, msg := range queue {
+		c.HandleUDPMessage(msg)
 	}
 }
 
 func (c *Connection) Disconnect() {
-	if c.AssignID == "" {
+	if id := c.AssignID(); id != "" {
+		if err := c.Write(utils.DisconnectCommand, id); err != nil {
+			c.LogError(fmt.Errorf("could not send disconnect command: %s", err))
+		}
+	}
+	c.Close()
+	if c.app != nil {
+		c.app.Stop()
+	}
+}
+
+func (c *Connection) DeleteMessage(message *server.Message) {
+	msg := *message
+	msg.AuthorID = c.AssignID() // identifies who is requesting the deletion
+	if err := c.Write(utils.DeleteMessageCommand, &msg); err != nil {
+		return
+	}
+}
+
+// HandleNotice forwards system notices to the message board, being kicked ends the session.
+func (c *Connection) HandleNotice(data []byte, kicked bool) {
+	var notice server.Notice
+	if err := json.Unmarshal(data, &notice); err != nil {
+		c.LogError(fmt.Errorf("failed to unmarshal notice"))
 		return
 	}
-	if err := utils.WriteToUDPConn(c.conn, utils.DisconnectCommand, c.AssignID); err != nil {
+	if kicked {
+		c.setIdentity("", c.Username(), c.Role())
+		c.SetState(Closed)
+		notice.Content += " You have been disconnected."
+	}
+	c.NoticeChan <- &notice
+}
+
+// HandleError logs a request rejected by the server, a server that no longer knows the session
+// means it restarted or dropped the client so it registers again.
+func (c *Connection) HandleError(data []byte) {
+	var packet server.ErrorPacket
+	if err := json.Unmarshal(data, &packet); err != nil {
+		c.LogError(fmt.Errorf("failed to unmarshal error"))
 		return
 	}
-	c.app.Stop()
+	if state := c.State(); packet.Code == server.UnknownClientCode && (state == Syncing || state == Live) {
+		c.Reconnect()
+	}
+	c.LogError(&packet)
 }
 
-func (c *Connection) DeleteMessage(message *server.Message) {
-	if err := utils.WriteToUDPConn(c.conn, utils.DeleteMessageCommand, message); err != nil {
+// AssignID returns the id the server assigned to this session, empty until registered or once kicked.
+func (c *Connection) AssignID() string {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return c.assignID
+}
+
+// Username returns the nickname the server granted, which differs from the requested one when it was taken.
+func (c *Connection) Username() string {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return c.name
+}
+
+// Role returns the role the server granted to this session.
+func (c *Connection) Role() string {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return c.role
+}
+
+// setIdentity records the session granted by the server, only the run loop calls it.
+func (c *Connection) setIdentity(id string, name string, role string) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.assignID = id
+	c.name = name
+	c.role = role
+}
+
+// Synced returns the room and server time of the last initial payload, which cached messages are saved with.
+func (c *Connection) Synced() (string, time.Time) {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return c.room, c.syncedAt
+}
+
+// setSynced records the sync point of an initial payload, only the run loop calls it.
+func (c *Connection) setSynced(room string, at time.Time) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.room = room
+	c.syncedAt = at
+}
+
+// IsModerator reports whether the server granted moderation rights to this connection.
+func (c *Connection) IsModerator() bool {
+	role := c.Role()
+	return role == server.RoleOwner || role == server.RoleModerator
+}
+
+func (c *Connection) Moderate(input *server.ModerationInput) {
+	input.IssuerID = c.AssignID()
+	if err := c.Write(utils.ModerateCommand, input); err != nil {
+		c.LogError(fmt.Errorf("could not send moderation command: %s", err))
+	}
+}
+
+func (c *Connection) HandlePresence(data []byte) {
+	var event server.PresenceEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		c.LogError(fmt.Errorf("failed to unmarshal presence event"))
+		return
+	}
+	if event.Type == server.RenamePresence && event.OldName == c.Username() {
+		c.setIdentity(c.AssignID(), event.Name, c.Role())
+	}
+	c.PresenceChan <- &event
+}
+
+func (c *Connection) HandleWhois(data []byte) {
+	var info server.WhoisInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		c.LogError(fmt.Errorf("failed to unmarshal whois info"))
+		return
+	}
+	c.WhoisChan <- &info
+}
+
+func (c *Connection) SetAway(away bool, message string) {
+	input := &server.AwayInput{ClientID: c.AssignID(), Away: away, Message: message}
+	if err := c.Write(utils.AwayCommand, input); err != nil {
+		c.LogError(fmt.Errorf("could not send away command: %s", err))
+	}
+}
+
+func (c *Connection) Whois(name string) {
+	input := &server.WhoisInput{ClientID: c.AssignID(), Name: name}
+	if err := c.Write(utils.WhoisCommand, input); err != nil {
+		c.LogError(fmt.Errorf("could not send whois command: %s", err))
+	}
+}
+
+func (c *Connection) Nick(name string) {
+	input := &server.NickInput{ClientID: c.AssignID(), Name: name}
+	if err := c.Write(utils.NickCommand, input); err != nil {
+		c.LogError(fmt.Errorf("could not send nick command: %s", err))
+	}
+}
+
+func (c *Connection) HandleTyping(data []byte) {
+	var event server.TypingEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		c.LogError(fmt.Errorf("failed to unmarshal typing event"))
+		return
+	}
+	c.TypingChan <- &event
+}
+
+func (c *Connection) SendTyping(typing bool) {
+	if c.AssignID() == "" {
+		return
+	}
+	input := &server.TypingInput{ClientID: c.AssignID(), Typing: typing}
+	if err := c.Write(utils.TypingCommand, input); err != nil {
+		c.LogError(fmt.Errorf("could not send typing command: %s", err))
+	}
+}
+
+// ReactionUpdate is a reaction event received from server with the kind of change.
+type ReactionUpdate struct {
+	*server.ReactionEvent
+	Added bool
+}
+
+func (c *Connection) HandleReaction(data []byte, added bool) {
+	var event server.ReactionEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		c.LogError(fmt.Errorf("failed to unmarshal reaction event"))
+		return
+	}
+	c.ReactionChan <- &ReactionUpdate{ReactionEvent: &event, Added: added}
+}
+
+func (c *Connection) React(messageID string, emoji string, add bool) {
+	command := utils.AddReactionCommand
+	if !add {
+		command = utils.RemoveReactionCommand
+	}
+	input := &server.ReactionInput{ClientID: c.AssignID(), MessageID: messageID, Emoji: emoji}
+	if err := c.Write(command, input); err != nil {
+		c.LogError(fmt.Errorf("could not send reaction: %s", err))
+	}
+}
+
+func (c *Connection) HandleMentions(data []byte) {
+	var payload server.MentionsPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		c.LogError(fmt.Errorf("failed to unmarshal mentions"))
 		return
 	}
+	c.MentionsChan <- payload.Messages
+}
+
+func (c *Connection) RequestMentions() {
+	input := &server.MentionsInput{ClientID: c.AssignID()}
+	if err := c.Write(utils.MentionsCommand, input); err != nil {
+		c.LogError(fmt.Errorf("could not request mentions: %s", err))
+	}
 }
@@ -0,0 +1,279 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/server"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+// FakeServer answers client registrations with a history it loses and reorders on purpose.
+type FakeServer struct {
+	conn     *net.UDPConn
+	history  []*server.Message
+	live     []*server.Message // sent while the history is being received
+	lose     map[int]int       // sends of a history order lost before one gets through
+	connects int
+	dropped  int               // registrations lost before one is answered
+	older    []*server.Message // single page of older history
+	losePage map[int]int       // sends of a page order lost before one gets through
+	headers  int               // page headers lost before one gets through
+}
+
+func StartFakeServer(t *testing.T, history []*server.Message, live []*server.Message) *FakeServer {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal("could not start fake server: ", err)
+	}
+	s := &FakeServer{conn: conn, history: history, live: live, lose: map[int]int{}, losePage: map[int]int{}}
+	t.Cleanup(func() { conn.Close() })
+	return s
+}
+
+func (s *FakeServer) Listen() {
+	random := rand.New(rand.NewSource(1))
+	for {
+		bytes, addr, err := utils.ReadUDPConn(s.conn)
+		if err != nil {
+			return
+		}
+		command, data := utils.ParseCommandAndData(bytes)
+		switch command {
+		case utils.ConnectCommand:
+			s.connects += 1
+			if s.connects <= s.dropped {
+				continue
+			}
+			logs := make([][]byte, 0, len(s.history))
+			for order, message := range s.history {
+				if !s.lost(order) {
+					logs = append(logs, utils.BuildUDPMessage(utils.AddHistoryCommand, &server.HistoryLog{Order: order, Message: message}))
+				}
+			}
+			random.Shuffle(len(logs), func(i, j int) { logs[i], logs[j] = logs[j], logs[i] })
+			s.send(addr, logs[0]) // overtakes the initial payload
+			s.send(addr, utils.BuildUDPMessage(utils.InitialPayloadCommand, &server.InitialPayload{
+				AssignedId:    "client",
+				Username:      "alice",
+				HistoryLength: len(s.history),
+				SyncedAt:      time.Now(),
+			}))
+			for i, log := range logs[1:] {
+				if i < len(s.live) {
+					s.send(addr, utils.BuildUDPMessage(utils.AddMessageCommand, s.live[i]))
+				}
+				s.send(addr, log)
+			}
+		case utils.ResendHistoryCommand:
+			var input server.ResendHistoryInput
+			if err := json.Unmarshal(data, &input); err != nil {
+				continue
+			}
+			for _, order := range input.Orders {
+				if s.lost(order) {
+					continue
+				}
+				s.send(addr, utils.BuildUDPMessage(utils.AddHistoryCommand, &server.HistoryLog{Order: order, Message: s.history[order]}))
+			}
+		case utils.HistoryPageCommand:
+			var input server.HistoryPageInput
+			if err := json.Unmarshal(data, &input); err != nil {
+				continue
+			}
+			if s.headers == 0 {
+				s.send(addr, utils.BuildUDPMessage(utils.HistoryPageCommand, &server.HistoryPage{Before: input.Before, Length: len(s.older)}))
+			} else {
+				s.headers -= 1
+			}
+			for order, message := range s.older {
+				if s.losePage[order] != 0 {
+					s.losePage[order] -= 1
+					continue
+				}
+				s.send(addr, utils.BuildUDPMessage(utils.AddHistoryCommand, &server.HistoryLog{Order: order, Message: message, Page: input.Before}))
+			}
+		}
+	}
+}
+
+func (s *FakeServer) lost(order int) bool {
+	if s.lose[order] == 0 {
+		return false
+	}
+	s.lose[order] -= 1
+	return true
+}
+
+func (s *FakeServer) send(addr *net.UDPAddr, msg []byte) {
+	s.conn.WriteToUDP(msg, addr)
+}
+
+func CreateTestMessages(prefix string, n int) []*server.Message {
+	messages := make([]*server.Message, n)
+	for i := range messages {
+		messages[i] = &server.Message{ID: fmt.Sprintf("%s-%d", prefix, i), Content: fmt.Sprintf("%s %d", prefix, i)}
+	}
+	return messages
+}
+
+// IsolateTestConfig keeps identities and caches saved by test clients out of the user config dir.
+func IsolateTestConfig(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+}
+
+// ConnectTestClient connects with short timeouts, events other than history and messages are discarded.
+func ConnectTestClient(t *testing.T, address string, username string) *Connection {
+	t.Helper()
+	c := NewConnection(nil)
+	c.ConnectTimeout = 100 * time.Millisecond
+	c.SyncTimeout = 100 * time.Millisecond
+	go func() {
+		for {
+			select {
+			case <-c.RosterChan:
+			case <-c.LogChan:
+			case <-c.NoticeChan:
+			case <-c.PresenceChan:
+			case <-c.TypingChan:
+			case <-c.ReactionChan:
+			case <-c.MessageDeleteChan:
+			case <-c.done:
+				return
+			}
+		}
+	}()
+	if err := c.Connect(address, username); err != nil {
+		t.Fatal("could not connect: ", err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func ReadTestHistory(t *testing.T, c *Connection) []*server.Message {
+	t.Helper()
+	select {
+	case history := <-c.HistoryChan:
+		return history
+	case <-time.After(3 * time.Second):
+		t.Fatal("initial history was not received")
+	}
+	return nil
+}
+
+func TestConnection_Sync(t *testing.T) {
+	history := CreateTestMessages("history", 20)
+	live := CreateTestMessages("live", 5)
+	s := StartFakeServer(t, history, live)
+	s.dropped = 1 // first registration is lost
+	s.lose[3], s.lose[11], s.lose[19] = 1, 2, 1
+	go s.Listen()
+	IsolateTestConfig(t)
+
+	c := ConnectTestClient(t, s.conn.LocalAddr().String(), "alice")
+	assert.Equal(t, history, ReadTestHistory(t, c), "history should be complete and ordered")
+	assert.Equal(t, Live, c.State())
+	for _, expected := range live {
+		select {
+		case data := <-c.MessageChan:
+			var message server.Message
+			assert.NoError(t, json.Unmarshal(data, &message))
+			assert.Equal(t, expected.ID, message.ID, "live messages should be applied in order after the history")
+		case <-time.After(time.Second):
+			t.Fatalf("live message \"%s\" was not received", expected.ID)
+		}
+	}
+}
+
+func TestConnection_PartialSync(t *testing.T) {
+	history := CreateTestMessages("history", 5)
+	s := StartFakeServer(t, history, nil)
+	s.lose[4] = HistorySyncRetries + 1 // never gets through
+	go s.Listen()
+	IsolateTestConfig(t)
+
+	c := ConnectTestClient(t, s.conn.LocalAddr().String(), "alice")
+	received := ReadTestHistory(t, c)
+	assert.Equal(t, history[:4], received, "received history should be shown once retries run out")
+	assert.Equal(t, Live, c.State())
+}
+
+// ReadTestPage waits for the next history page delivered to the board.
+func ReadTestPage(t *testing.T, c *Connection) *HistoryPage {
+	t.Helper()
+	select {
+	case page := <-c.HistoryPageChan:
+		return page
+	case <-time.After(3 * time.Second):
+		t.Fatal("history page was not received")
+	}
+	return nil
+}
+
+func TestConnection_HistoryPage(t *testing.T) {
+	history := CreateTestMessages("history", 3)
+	older := CreateTestMessages("older", 10)
+	IsolateTestConfig(t)
+	requestPage := func(t *testing.T, s *FakeServer) *HistoryPage {
+		s.older = older
+		go s.Listen()
+		c := ConnectTestClient(t, s.conn.LocalAddr().String(), "alice")
+		ReadTestHistory(t, c)
+		c.RequestHistoryPage(history[0].ID)
+		return ReadTestPage(t, c)
+	}
+
+	t.Run("Lost page packets are requested again", func(t *testing.T) {
+		s := StartFakeServer(t, history, nil)
+		s.headers = 1
+		s.losePage[7] = 2
+		page := requestPage(t, s)
+		assert.Equal(t, history[0].ID, page.Before)
+		assert.Equal(t, older, page.Messages)
+	})
+
+	t.Run("Received messages are delivered once retries run out", func(t *testing.T) {
+		s := StartFakeServer(t, history, nil)
+		s.losePage[2] = HistorySyncRetries + 1 // never gets through
+		page := requestPage(t, s)
+		assert.Equal(t, append(append([]*server.Message{}, older[:2]...), older[3:]...), page.Messages)
+	})
+}
+
+func TestConnection_HandleError(t *testing.T) {
+	c := NewConnection(nil)
+	go c.HandleError(utils.BuildUDPMessage("", &server.ErrorPacket{Code: server.NotFoundCode, Message: "The message to delete doesnt exist anymore.", Request: utils.DeleteMessageCommand}))
+	select {
+	case err := <-c.LogChan:
+		var packet *server.ErrorPacket
+		if assert.True(t, errors.As(err, &packet), "server errors should be logged as they were received") {
+			assert.Equal(t, server.NotFoundCode, packet.Code)
+		}
+		assert.Equal(t, "The message to delete doesnt exist anymore. (delete_message not_found)", err.Error())
+	case <-time.After(time.Second):
+		t.Fatal("error was not logged")
+	}
+}
+
+func TestConnection_UnknownClient(t *testing.T) {
+	IsolateTestConfig(t)
+	s := StartFakeServer(t, CreateTestMessages("history", 3), nil)
+	go s.Listen()
+	c := ConnectTestClient(t, s.conn.LocalAddr().String(), "alice")
+	ReadTestHistory(t, c)
+
+	// the server restarted and lost the session
+	s.send(c.conn.LocalAddr().(*net.UDPAddr), utils.BuildUDPMessage(utils.ErrorCommand, &server.ErrorPacket{
+		Code: server.UnknownClientCode, Message: "You are not connected, reconnect to continue.", Request: utils.AddMessageCommand,
+	}))
+	assert.Len(t, ReadTestHistory(t, c), 3, "the client should register again and receive the history")
+	assert.Equal(t, Live, c.State())
+	assert.Equal(t, "client", c.AssignID())
+}
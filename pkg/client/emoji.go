@@ -0,0 +1,57 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// emojis maps supported short codes to the emoji rendered on the board, unknown codes are shown as is.
+var emojis = map[string]string{
+	":thumbsup:":   "👍",
+	":+1:":         "👍",
+	":thumbsdown:": "👎",
+	":-1:":         "👎",
+	":heart:":      "❤️",
+	":smile:":      "😄",
+	":laughing:":   "😆",
+	":tada:":       "🎉",
+	":eyes:":       "👀",
+	":fire:":       "🔥",
+	":rocket:":     "🚀",
+	":thinking:":   "🤔",
+	":clap:":       "👏",
+	":ok_hand:":    "👌",
+	":pray:":       "🙏",
+	":cry:":        "😢",
+}
+
+func RenderEmoji(code string) string {
+	if emoji, ok := emojis[code]; ok {
+		return emoji
+	}
+	return code
+}
+
+// GenerateReactionsLog renders compact reaction counts, reactions of the current user are highlighted.
+func GenerateReactionsLog(reactions map[string][]string, username string) string {
+	if len(reactions) == 0 {
+		return ""
+	}
+	codes := make([]string, 0, len(reactions))
+	for code := range reactions {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	parts := make([]string, 0, len(codes))
+	for _, code := range codes {
+		color := "grey"
+		for _, name := range reactions[code] {
+			if name == username {
+				color = "blue"
+			}
+		}
+		parts = append(parts, fmt.Sprintf("[%s]%s %d[::-]", color, RenderEmoji(code), len(reactions[code])))
+	}
+	return "  " + strings.Join(parts, "  ") + "\n"
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/server"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var exportReg = regexp.MustCompile(`^/export (\S+)$`)
+
+// ExportFormat picks the archive format from the file extension, JSON Lines unless it is a transcript.
+func ExportFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".md", ".markdown":
+		return server.MarkdownFormat
+	case ".txt", ".log":
+		return server.TextFormat
+	default:
+		return server.JSONLFormat
+	}
+}
+
+// Export saves the messages loaded on the board to a file.
+func (board *MessageBoard) Export(path string) {
+	board.mu.Lock()
+	messages := make([]*server.Message, len(board.Store))
+	copy(messages, board.Store)
+	board.mu.Unlock()
+
+	file, err := os.Create(path)
+	if err != nil {
+		board.Connection.LogError(fmt.Errorf("could not create export file: %s", err))
+		return
+	}
+	defer file.Close()
+	if err := server.WriteArchive(file, &server.Archive{Messages: messages}, ExportFormat(path)); err != nil {
+		board.Connection.LogError(err)
+		return
+	}
+	board.StreamToMessageView(fmt.Sprintf("[lightgrey::b]Exported %d messages to %s[::-]\n\n", len(messages), path))
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gdamore/tcell/v2"
+	"github.com/hirotachi/udp-cli-chat/pkg/server"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"strings"
+)
+
+// HistoryPage holds older messages requested while scrolling back.
+type HistoryPage struct {
+	Before   string
+	Messages []*server.Message // nil until the page header is received
+	HasMore  bool
+	received int
+}
+
+// RequestHistoryPage asks the run loop for the messages preceding the before cursor.
+func (c *Connection) RequestHistoryPage(before string) {
+	select {
+	case c.pageRequests <- before:
+	case <-c.done:
+	}
+}
+
+// StartHistoryPage requests a page and waits for its logs, a page already on its way is dropped.
+func (c *Connection) StartHistoryPage(before string) {
+	if c.State() != Live { // the board requests again once the initial history replaced it
+		return
+	}
+	c.page = &HistoryPage{Before: before, HasMore: true}
+	c.attempts = 0
+	c.progress = 0
+	c.SendHistoryPageRequest(before)
+	c.resetTimer(c.SyncTimeout)
+}
+
+func (c *Connection) SendHistoryPageRequest(before string) {
+	input := &server.HistoryPageInput{ClientID: c.AssignID(), Before: before, Limit: server.DefaultPageLimit}
+	if err := c.Write(utils.HistoryPageCommand, input); err != nil {
+		c.LogError(fmt.Errorf("could not request older history: %s", err))
+	}
+}
+
+// HandleHistoryPage prepares to receive the history logs of the requested page,
+// headers of a page requested again keep the logs already received.
+func (c *Connection) HandleHistoryPage(data []byte) {
+	var header server.HistoryPage
+	if err := json.Unmarshal(data, &header); err != nil {
+		c.LogError(fmt.Errorf("failed to unmarshal history page"))
+		return
+	}
+	page := c.page
+	if page == nil || page.Before != header.Before || page.Messages != nil {
+		return
+	}
+	page.Messages = make([]*server.Message, header.Length)
+	page.HasMore = header.HasMore
+	if header.Length == 0 {
+		c.DeliverHistoryPage()
+	}
+}
+
+// AddMessageToPage places a history log in the pending page and delivers the page once complete.
+func (c *Connection) AddMessageToPage(data []byte) {
+	var historyLog server.HistoryLog
+	if err := json.Unmarshal(data, &historyLog); err != nil {
+		c.LogError(fmt.Errorf("could not unmarshal history log"))
+		return
+	}
+	page := c.page
+	if page == nil || page.Before != historyLog.Page || historyLog.Order < 0 || historyLog.Order >= len(page.Messages) {
+		return
+	}
+	if page.Messages[historyLog.Order] == nil {
+		page.received += 1
+	}
+	page.Messages[historyLog.Order] = historyLog.Message
+	if page.received == len(page.Messages) {
+		c.DeliverHistoryPage()
+	}
+}
+
+// HandlePageTimeout requests a page again when its logs stop coming, and delivers what was received
+// once retries run out so scrolling back can continue.
+func (c *Connection) HandlePageTimeout() {
+	page := c.page
+	if page == nil {
+		return
+	}
+	if page.received != c.progress { // still receiving
+		c.progress = page.received
+		c.resetTimer(c.SyncTimeout)
+		return
+	}
+	if c.attempts == c.SyncRetries {
+		c.LogError(fmt.Errorf("older history is incomplete, %d of %d messages could not be loaded", len(page.Messages)-page.received, len(page.Messages)))
+		c.DeliverHistoryPage()
+		return
+	}
+	c.attempts += 1
+	c.SendHistoryPageRequest(page.Before)
+	c.resetTimer(c.SyncTimeout)
+}
+
+// DeliverHistoryPage hands the received messages of the pending page to the board.
+func (c *Connection) DeliverHistoryPage() {
+	page := c.page
+	c.page = nil
+	messages := make([]*server.Message, 0, page.received)
+	for _, message := range page.Messages {
+		if message != nil {
+			messages = append(messages, message)
+		}
+	}
+	page.Messages = messages
+	c.HistoryPageChan <- page
+}
+
+// LoadOlderHistory requests the page before the oldest loaded message unless one is on its way.
+func (board *MessageBoard) LoadOlderHistory() {
+	board.mu.Lock()
+	defer board.mu.Unlock()
+	board.requestOlderHistory()
+}
+
+// requestOlderHistory callers must hold the board lock.
+func (board *MessageBoard) requestOlderHistory() {
+	if board.loadingPage || board.historyStart || board.Connection.AssignID() == "" {
+		return
+	}
+	before := server.LatestPage
+	if len(board.Store) != 0 {
+		before = board.Store[0].ID
+	}
+	board.loadingPage = true
+	go board.Connection.RequestHistoryPage(before)
+}
+
+// ListenToHistoryPages prepends older messages to the board keeping the current lines in view.
+func (board *MessageBoard) ListenToHistoryPages() {
+	for page := range board.Connection.HistoryPageChan {
+		board.mu.Lock()
+		board.loadingPage = false
+		board.historyStart = !page.HasMore
+		older := make([]*server.Message, 0, len(page.Messages))
+		for _, message := range page.Messages {
+			if board.FindMessage(message.ID) == nil {
+				older = append(older, message)
+			}
+		}
+		lines := 0
+		for _, message := range older {
+			if !board.IsVisible(message) {
+				continue
+			}
+			for _, str := range board.GenerateMessageLog(message) {
+				lines += strings.Count(str.(string), "\n")
+			}
+		}
+		board.Store = append(older, board.Store...)
+		board.cacheDirty = true
+		board.Rerender()
+		board.View.ScrollTo(lines, 0)
+		board.JumpToTarget()
+		board.mu.Unlock()
+	}
+}
+
+// CaptureScroll loads older history when the user keeps scrolling up at the top of the board.
+func (board *MessageBoard) CaptureScroll(event *tcell.EventKey) *tcell.EventKey {
+	row, _ := board.View.GetScrollOffset()
+	if row != 0 {
+		return event
+	}
+	switch event.Key() {
+	case tcell.KeyUp, tcell.KeyPgUp, tcell.KeyHome:
+		board.LoadOlderHistory()
+	case tcell.KeyRune:
+		if event.Rune() == 'k' || event.Rune() == 'g' {
+			board.LoadOlderHistory()
+		}
+	}
+	return event
+}
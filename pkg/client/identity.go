@@ -0,0 +1,62 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// identitiesPath is where assigned ids are remembered per server so users keep their account and nickname.
+func identitiesPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "udp-cli-chat", "identities.json"), nil
+}
+
+func loadIdentities() map[string]string {
+	identities := map[string]string{}
+	path, err := identitiesPath()
+	if err != nil {
+		return identities
+	}
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return identities
+	}
+	if err := json.Unmarshal(bytes, &identities); err != nil {
+		return map[string]string{}
+	}
+	return identities
+}
+
+// LoadIdentity returns the id previously assigned by server, empty if none.
+func LoadIdentity(serverAddress string) string {
+	return loadIdentities()[serverAddress]
+}
+
+// SaveIdentity remembers the id assigned by server.
+func SaveIdentity(serverAddress string, assignedID string) error {
+	path, err := identitiesPath()
+	if err != nil {
+		return fmt.Errorf("could not locate config dir: %s", err)
+	}
+	identities := loadIdentities()
+	if identities[serverAddress] == assignedID {
+		return nil
+	}
+	identities[serverAddress] = assignedID
+	bytes, err := json.Marshal(identities)
+	if err != nil {
+		return fmt.Errorf("could not marshal identities: %s", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("could not create config dir: %s", err)
+	}
+	if err := os.WriteFile(path, bytes, 0600); err != nil {
+		return fmt.Errorf("could not save identity: %s", err)
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 type InputSection struct {
 	View         *tview.InputField
 	MessageBoard *MessageBoard
+	Typing       *TypingNotifier
 	Focus        func(view string)
 }
 
@@ -19,7 +20,12 @@ func NewInputSection(messageBoard *MessageBoard) *InputSection {
 	inputView.SetLabel(">").SetLabelColor(tcell.ColorDeepSkyBlue).SetLabelWidth(2)
 	inputView.SetFieldTextColor(tcell.ColorWhite).SetFieldBackgroundColor(tcell.ColorGrey)
 
-	inputSection := &InputSection{View: inputView, MessageBoard: messageBoard}
+	inputSection := &InputSection{
+		View:         inputView,
+		MessageBoard: messageBoard,
+		Typing:       &TypingNotifier{Connection: messageBoard.Connection},
+	}
+	inputView.SetChangedFunc(inputSection.Typing.Changed)
 	inputView.SetDoneFunc(func(key tcell.Key) {
 		switch key {
 		case tcell.KeyEnter:
@@ -27,6 +33,7 @@ func NewInputSection(messageBoard *MessageBoard) *InputSection {
 			if text == "" {
 				return
 			}
+			inputSection.Typing.Stop()
 			messageBoard.HandleInput(strings.TrimSpace(text))
 			inputView.SetText("")
 		case tcell.KeyUp:
@@ -0,0 +1,224 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis/v8"
+	"github.com/hirotachi/udp-cli-chat/pkg/netsim"
+	"github.com/hirotachi/udp-cli-chat/pkg/server"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ConvergeTimeout is how long clients behind an impaired network get to catch up with the server.
+const ConvergeTimeout = 10 * time.Second
+
+func StartTestServer(t *testing.T) *server.Server {
+	t.Helper()
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal("error creating redis db: ", err)
+	}
+	t.Cleanup(mr.Close)
+	s, err := server.NewServer("127.0.0.1:0", redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	if err != nil {
+		t.Fatal("error creating UDP server: ", err)
+	}
+	s.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	if err := s.Listen(); err != nil {
+		t.Fatal("error listening on UDP server: ", err)
+	}
+	s.Chat.HistoryLimit = 1000 // views are compared with the whole history
+	go s.Run()
+	return s
+}
+
+func StartTestProxy(t *testing.T, s *server.Server, seed int64, upstream netsim.Impairment, downstream netsim.Impairment) *netsim.Proxy {
+	t.Helper()
+	proxy, err := netsim.NewProxy(s.Addr().String(), seed)
+	if err != nil {
+		t.Fatal("could not start proxy: ", err)
+	}
+	proxy.Impair(upstream, downstream)
+	t.Cleanup(func() { proxy.Close() })
+	return proxy
+}
+
+// SeedTestHistory sends messages to the server over a clean connection.
+func SeedTestHistory(t *testing.T, s *server.Server, n int) {
+	t.Helper()
+	conn, err := utils.GetUDPConnection(s.Addr().String())
+	if err != nil {
+		t.Fatal("could not connect to server: ", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	read := func(command string) []byte {
+		for {
+			bytes, _, err := utils.ReadUDPConn(conn)
+			if err != nil {
+				t.Fatalf("did not receive \"%s\": %s", command, err)
+			}
+			if cmd, data := utils.ParseCommandAndData(bytes); cmd == command {
+				return data
+			}
+		}
+	}
+	if err := utils.WriteToUDPConn(conn, utils.ConnectCommand, &server.LoginInput{Username: "seeder"}); err != nil {
+		t.Fatal("could not write to UDP connection: ", err)
+	}
+	var payload server.InitialPayload
+	if err := json.Unmarshal(read(utils.InitialPayloadCommand), &payload); err != nil {
+		t.Fatal("invalid initial payload: ", err)
+	}
+	for i := 0; i < n; i++ {
+		message := &server.Message{AuthorID: payload.AssignedId, Content: fmt.Sprintf("seed %d", i)}
+		if err := utils.WriteToUDPConn(conn, utils.AddMessageCommand, message); err != nil {
+			t.Fatal("could not write to UDP connection: ", err)
+		}
+		read(utils.AddMessageCommand)
+	}
+	utils.WriteToUDPConn(conn, utils.DisconnectCommand, payload.AssignedId)
+}
+
+// TestView is what a client shows, built the same way as the message board store.
+type TestView struct {
+	mu       sync.Mutex
+	messages []*server.Message
+	err      error // set by Follow, which runs on its own goroutine and can't fail the test
+}
+
+// Follow loads the initial history of c then inserts its live messages.
+func (v *TestView) Follow(c *Connection) {
+	select {
+	case history := <-c.HistoryChan:
+		v.mu.Lock()
+		v.messages = history
+		v.mu.Unlock()
+	case <-time.After(ConvergeTimeout):
+		v.mu.Lock()
+		v.err = fmt.Errorf("initial history was not received")
+		v.mu.Unlock()
+		return
+	}
+	for {
+		select {
+		case data := <-c.MessageChan:
+			var message server.Message
+			if err := json.Unmarshal(data, &message); err != nil {
+				continue
+			}
+			v.mu.Lock()
+			v.messages, _ = InsertMessage(v.messages, &message)
+			v.mu.Unlock()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (v *TestView) Err() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.err
+}
+
+func (v *TestView) Lines() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return MessageLines(v.messages)
+}
+
+func MessageLines(messages []*server.Message) []string {
+	lines := make([]string, 0, len(messages))
+	for _, message := range messages {
+		lines = append(lines, message.ID+" "+message.Content)
+	}
+	return lines
+}
+
+// AssertConverges waits until every view shows the server history.
+func AssertConverges(t *testing.T, s *server.Server, views map[string]*TestView) {
+	t.Helper()
+	deadline := time.Now().Add(ConvergeTimeout)
+	for {
+		expected := MessageLines(s.Chat.Export().Messages)
+		converged := true
+		for _, view := range views {
+			if !assert.ObjectsAreEqual(expected, view.Lines()) {
+				converged = false
+			}
+		}
+		if converged {
+			return
+		}
+		if time.Now().After(deadline) {
+			for name, view := range views {
+				assert.NoError(t, view.Err(), "%s should follow the chat", name)
+				assert.Equal(t, expected, view.Lines(), "%s should show the server history", name)
+			}
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestIntegration_HistorySync(t *testing.T) {
+	IsolateTestConfig(t)
+	s := StartTestServer(t)
+	SeedTestHistory(t, s, 40)
+	lossy := netsim.Impairment{Drop: .15, Duplicate: .1, Reorder: .2, Jitter: 10 * time.Millisecond}
+	proxy := StartTestProxy(t, s, 1, lossy, lossy)
+
+	c := ConnectTestClient(t, proxy.Addr().String(), "alice")
+	c.SyncRetries = 20
+	view := &TestView{}
+	go view.Follow(c)
+	AssertConverges(t, s, map[string]*TestView{"alice": view})
+}
+
+func TestIntegration_Messages(t *testing.T) {
+	downstream := netsim.Impairment{Duplicate: .1, Reorder: .2, Jitter: 10 * time.Millisecond}
+	RunTestMessages(t, downstream)
+}
+
+func TestIntegration_LostMessages(t *testing.T) {
+	t.Skip("clients don't recover live messages lost on the way to them yet, the server never sends them again")
+	downstream := netsim.Impairment{Drop: .1, Duplicate: .1, Reorder: .2, Jitter: 10 * time.Millisecond}
+	RunTestMessages(t, downstream)
+}
+
+// RunTestMessages has clients send messages through lossy upstreams and checks that every message the
+// server accepted reaches every client through downstream.
+func RunTestMessages(t *testing.T, downstream netsim.Impairment) {
+	IsolateTestConfig(t)
+	s := StartTestServer(t)
+	SeedTestHistory(t, s, 5)
+	upstream := netsim.Impairment{Drop: .1, Duplicate: .1, Reorder: .2, Corrupt: .05, Jitter: 10 * time.Millisecond}
+
+	views := map[string]*TestView{}
+	clients := map[string]*Connection{}
+	for i, name := range []string{"alice", "bob", "carol"} {
+		proxy := StartTestProxy(t, s, int64(i+1), upstream, downstream) // own address so identities don't mix up
+		c := ConnectTestClient(t, proxy.Addr().String(), name)
+		c.SyncRetries = 20
+		views[name], clients[name] = &TestView{}, c
+		go views[name].Follow(c)
+	}
+	AssertConverges(t, s, views)
+
+	for i := 0; i < 10; i++ {
+		for name, c := range clients {
+			c.Write(utils.AddMessageCommand, &server.Message{AuthorID: c.AssignID(), Content: fmt.Sprintf("%s %d", name, i)})
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	AssertConverges(t, s, views)
+	assert.Greater(t, len(s.Chat.Export().Messages), 5, "the server should accept live messages")
+}
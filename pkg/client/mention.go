@@ -0,0 +1,64 @@
+package client
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell/v2"
+	"github.com/hirotachi/udp-cli-chat/pkg/server"
+	"github.com/rivo/tview"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+// MentionFlashDuration is how long the frame title stays highlighted after a mention.
+const MentionFlashDuration = 2 * time.Second
+
+// IsMentioned reports whether message mentions the current user.
+func (board *MessageBoard) IsMentioned(message *server.Message) bool {
+	return board.Connection.AssignID() != "" && message.Mentions(board.Connection.AssignID())
+}
+
+// HighlightMentions highlights mentions of the current user in content.
+func (board *MessageBoard) HighlightMentions(content string) string {
+	if board.Connection.Username() == "" {
+		return content
+	}
+	reg := regexp.MustCompile(`(?i)@` + regexp.QuoteMeta(board.Connection.Username()) + `\b`)
+	return reg.ReplaceAllStringFunc(content, func(mention string) string {
+		return fmt.Sprintf("[yellow::b]%s[white::-]", mention)
+	})
+}
+
+// Notify rings the terminal bell and flashes the frame title.
+func (board *MessageBoard) Notify() {
+	atomic.StoreInt32(&board.bell, 1)
+	board.app.QueueUpdateDraw(func() {
+		board.Frame.SetTitle(board.Title() + "[mentioned]").SetTitleColor(tcell.ColorYellow)
+	})
+	time.AfterFunc(MentionFlashDuration, func() {
+		board.app.QueueUpdateDraw(func() {
+			board.Frame.SetTitle(board.Title()).SetTitleColor(tview.Styles.TitleColor)
+		})
+	})
+}
+
+// RingBell beeps before the next draw if a notification is pending.
+func (board *MessageBoard) RingBell(screen tcell.Screen) bool {
+	if atomic.CompareAndSwapInt32(&board.bell, 1, 0) {
+		if err := screen.Beep(); err != nil {
+			go board.Connection.LogError(fmt.Errorf("failed to ring bell: %s", err))
+		}
+	}
+	return false
+}
+
+func (board *MessageBoard) ListenToMentions() {
+	for messages := range board.Connection.MentionsChan {
+		text := fmt.Sprintf("[lightgrey::b]Mentions (%d)[::-]\n", len(messages))
+		for _, message := range messages {
+			date := message.CreatedAt.Format("Jan 2 15:04:05")
+			text += fmt.Sprintf("  [grey]%s[::-] [white::b]%s[::-] %s\n", date, tview.Escape(message.AuthorName), board.HighlightMentions(tview.Escape(message.Content)))
+		}
+		board.StreamToMessageView(text, "\n")
+	}
+}
@@ -8,14 +8,30 @@ import (
 	"github.com/rivo/tview"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 )
 
 type MessageBoard struct {
+	mu             sync.Mutex
+	app            *tview.Application
+	bell           int32 // set when the bell should ring on next draw
 	View           *tview.TextView
 	Frame          *tview.Frame
 	Store          []*server.Message
 	Connection     *Connection
-	ClientMessages map[string]*server.Message
+	ClientMessages map[string]*server.Message // shown messages by tag
+	tags           map[string]string          // message id to tag, kept so tags never move
+	UserList       *UserList
+	ThreadRoot     string // id of the conversation shown in thread view, empty shows all messages
+	FirstUnread    string // id of the first message missed while offline, marked with a divider
+	loadingPage    bool   // an older history page was requested
+	historyStart   bool   // the oldest message of the chat is loaded
+	LastSearch     *server.SearchResults
+	SearchResults  map[string]*server.Message // search results by tag
+	jumpTarget     string                     // id of a search result to show once loaded
+	historyLoaded  bool                       // the server history replaced cached messages
+	cacheDirty     bool                       // messages changed since the cache was saved
 }
 
 func NewMessageBoard(app *tview.Application, connection *Connection) *MessageBoard {
@@ -25,36 +41,81 @@ func NewMessageBoard(app *tview.Application, connection *Connection) *MessageBoa
 	messageView.SetDynamicColors(true).SetScrollable(true).SetRegions(true)
 
 	messageFrame := tview.NewFrame(messageView)
-	messageFrame.SetTitle("[#Cocus chat]").SetBorder(true).SetTitleAlign(0)
+	messageFrame.SetTitle(DefaultTitle).SetBorder(true).SetTitleAlign(0)
 
 	messageBoard := &MessageBoard{
+		app:            app,
 		View:           messageView,
 		Frame:          messageFrame,
 		Store:          make([]*server.Message, 0),
 		Connection:     connection,
 		ClientMessages: map[string]*server.Message{},
+		tags:           map[string]string{},
+		SearchResults:  map[string]*server.Message{},
 	}
 
 	go messageBoard.ListenToHistoryLoad()
+	go messageBoard.ListenToState()
 	go messageBoard.ListenToMessages()
 	go messageBoard.ListenToConnectionLog()
 	go messageBoard.ListenToMessageDeletion()
+	go messageBoard.ListenToNotices()
+	go messageBoard.ListenToWhois()
+	go messageBoard.ListenToReactions()
+	go messageBoard.ListenToMentions()
+	go messageBoard.ListenToHistoryPages()
+	go messageBoard.ListenToSearch()
+	go messageBoard.ListenToCache()
+	go messageBoard.ListenToCacheSaves()
+	messageView.SetInputCapture(messageBoard.CaptureScroll)
+	app.SetBeforeDrawFunc(messageBoard.RingBell)
 
 	messageBoard.ShowWelcomeText()
 	return messageBoard
 }
 
+// ListenToHistoryLoad shows the initial history, received again after each reconnection.
 func (board *MessageBoard) ListenToHistoryLoad() {
-	history := <-board.Connection.HistoryChan
+	for history := range board.Connection.HistoryChan {
+		board.LoadHistory(history)
+	}
+}
+
+func (board *MessageBoard) LoadHistory(history []*server.Message) {
+	board.mu.Lock()
+	defer board.mu.Unlock()
+	shown := len(board.Store) != 0 // cached or previous session messages are on screen already
 	board.Store = history
-	historyLog := make([]interface{}, 0)
-	for _, message := range history {
-		msg := message
-		formattedMessage := board.GenerateMessageLog(msg)
-		historyLog = append(historyLog, formattedMessage...)
+	board.loadingPage = false // a page requested before the history was replaced is dropped
+	board.historyLoaded = true
+	board.cacheDirty = true
+	board.FirstUnread = board.FindFirstUnread(history)
+	if shown {
+		board.Rerender()
+	} else {
+		historyLog := make([]interface{}, 0)
+		for _, message := range history {
+			msg := message
+			historyLog = append(historyLog, board.GenerateUnreadDivider(msg)...)
+			formattedMessage := board.GenerateMessageLog(msg)
+			historyLog = append(historyLog, formattedMessage...)
+		}
+		board.StreamToMessageView(historyLog...)
 	}
-	board.StreamToMessageView(historyLog...)
 	board.View.ScrollToEnd()
+	go board.SaveCache()
+	if len(history) != 0 {
+		go board.Connection.MarkRead(history[len(history)-1].ID)
+	}
+}
+
+// ListenToState shows the connection state in the frame title while it is not live.
+func (board *MessageBoard) ListenToState() {
+	for range board.Connection.StateChan {
+		board.app.QueueUpdateDraw(func() {
+			board.Frame.SetTitle(board.Title())
+		})
+	}
 }
 
 func (board *MessageBoard) ListenToMessages() {
@@ -62,12 +123,50 @@ func (board *MessageBoard) ListenToMessages() {
 		var message server.Message
 		if err := json.Unmarshal(bytes, &message); err != nil {
 			board.Connection.LogError(fmt.Errorf("failed to unmarshal message: %s", err))
-			return
+			continue
+		}
+		board.mu.Lock()
+		var index int
+		board.Store, index = InsertMessage(board.Store, &message)
+		if index == -1 { // duplicated by the network
+			board.mu.Unlock()
+			continue
+		}
+		board.Connection.MarkRead(message.ID)
+		board.cacheDirty = true
+		if index != len(board.Store)-1 { // overtaken by a newer message
+			board.Rerender()
+			board.mu.Unlock()
+			continue
+		}
+		if !board.IsVisible(&message) {
+			board.mu.Unlock()
+			continue
 		}
-		board.Store = append(board.Store, &message)
 		formattedMessage := board.GenerateMessageLog(&message)
+		board.mu.Unlock()
 		board.StreamToMessageView(formattedMessage...)
+		if message.AuthorID != board.Connection.AssignID() && board.IsMentioned(&message) {
+			board.Notify()
+		}
+	}
+}
+
+// InsertMessage adds message to store in creation order and returns its index, -1 when it is already stored.
+func InsertMessage(store []*server.Message, message *server.Message) ([]*server.Message, int) {
+	for _, m := range store {
+		if m.ID == message.ID {
+			return store, -1
+		}
+	}
+	i := len(store)
+	for i > 0 && store[i-1].CreatedAt.After(message.CreatedAt) {
+		i--
 	}
+	store = append(store, nil)
+	copy(store[i+1:], store[i:])
+	store[i] = message
+	return store, i
 }
 
 func (board *MessageBoard) StreamToMessageView(data ...interface{}) {
@@ -79,11 +178,77 @@ func (board *MessageBoard) StreamToMessageView(data ...interface{}) {
 // ListenToConnectionLog log errors and announcements to message board
 func (board *MessageBoard) ListenToConnectionLog() {
 	for log := range board.Connection.LogChan {
-		board.StreamToMessageView("[red]error[::-]: ", log, "\n\n")
+		board.StreamToMessageView("[red]error[::-]: ", tview.Escape(log.Error()), "\n\n")
+	}
+}
+
+// ListenToNotices log system notices sent by the server to message board
+func (board *MessageBoard) ListenToNotices() {
+	for notice := range board.Connection.NoticeChan {
+		date := notice.CreatedAt.Format("Jan 2 15:04:05")
+		board.StreamToMessageView("[yellow::b]system[::-] [grey]", date, "[::-]\n  [yellow]", tview.Escape(notice.Content), "[::-]\n\n")
+	}
+}
+
+// ShowPresence log presence changes of other users to message board
+func (board *MessageBoard) ShowPresence(event *server.PresenceEvent) {
+	var text string
+	switch event.Type {
+	case server.JoinPresence:
+		text = fmt.Sprintf("%s joined", event.Name)
+	case server.LeavePresence:
+		text = fmt.Sprintf("%s left", event.Name)
+	case server.RenamePresence:
+		text = fmt.Sprintf("%s is now known as %s", event.OldName, event.Name)
+		board.RenameAuthor(event.OldName, event.Name)
+	case server.AwayPresence:
+		text = fmt.Sprintf("%s is away", event.Name)
+		if event.Message != "" {
+			text = fmt.Sprintf("%s: %s", text, event.Message)
+		}
+	case server.BackPresence:
+		text = fmt.Sprintf("%s is back", event.Name)
+	default:
+		return
+	}
+	board.StreamToMessageView("[grey]", tview.Escape(text), "[::-]\n\n")
+}
+
+func (board *MessageBoard) ListenToWhois() {
+	for info := range board.Connection.WhoisChan {
+		status := "offline, last seen " + info.LastSeen.Format("Jan 2 15:04:05")
+		if info.Online {
+			status = info.Status
+			if info.AwayMessage != "" {
+				status = fmt.Sprintf("%s (%s)", status, info.AwayMessage)
+			}
+		}
+		board.StreamToMessageView("[lightgrey::b]whois ", tview.Escape(info.Name), "[::-]\n  [lightgrey]role: ", tview.Escape(info.Role), "\n  status: ", tview.Escape(status), "[::-]\n\n")
+	}
+}
+
+// ShowOnlineUsers lists online users to message board
+func (board *MessageBoard) ShowOnlineUsers() {
+	entries := board.UserList.Entries()
+	text := fmt.Sprintf("[lightgrey::b]Online (%d)[::-]\n", len(entries))
+	for _, entry := range entries {
+		text += "  " + FormatRosterEntry(entry)
+		if entry.AwayMessage != "" {
+			text += fmt.Sprintf(" [grey](%s)[::-]", tview.Escape(entry.AwayMessage))
+		}
+		text += "\n"
 	}
+	board.StreamToMessageView(text, "\n")
 }
 
 var deletionReg = regexp.MustCompile(`/delete T\d+$`)
+var whoisReg = regexp.MustCompile(`^/whois (\S+)$`)
+var nickReg = regexp.MustCompile(`^/nick (\S+)$`)
+var replyReg = regexp.MustCompile(`^/reply (T\d+) (.+)$`)
+var threadReg = regexp.MustCompile(`^/thread (T\d+)$`)
+var reactionReg = regexp.MustCompile(`^/(react|unreact) (T\d+) (:[a-z0-9_+-]+:)$`)
+var awayReg = regexp.MustCompile(`^/away(?: (.*))?$`)
+var moderationReg = regexp.MustCompile(`^/(kick|ban|unban|mute|unmute|promote|demote) (\S+)(?: (.*))?$`)
 
 func (board *MessageBoard) HandleInput(text string) {
 	if deletionReg.MatchString(text) {
@@ -91,17 +256,68 @@ func (board *MessageBoard) HandleInput(text string) {
 		board.HandleDeleteMessageByTag(tag)
 		return
 	}
+	if match := moderationReg.FindStringSubmatch(text); match != nil {
+		board.HandleModeration(match[1], match[2], match[3])
+		return
+	}
+	if match := replyReg.FindStringSubmatch(text); match != nil {
+		board.Reply(match[1], strings.TrimSpace(match[2]))
+		return
+	}
+	if match := reactionReg.FindStringSubmatch(text); match != nil {
+		board.HandleReactionByTag(match[2], match[3], match[1] == "react")
+		return
+	}
+	if match := threadReg.FindStringSubmatch(text); match != nil {
+		board.OpenThread(match[1])
+		return
+	}
+	if match := nickReg.FindStringSubmatch(text); match != nil {
+		board.Connection.Nick(match[1])
+		return
+	}
+	if match := whoisReg.FindStringSubmatch(text); match != nil {
+		board.Connection.Whois(match[1])
+		return
+	}
+	if match := searchReg.FindStringSubmatch(text); match != nil {
+		board.Connection.Search(strings.TrimSpace(match[1]), 0)
+		return
+	}
+	if match := exportReg.FindStringSubmatch(text); match != nil {
+		go board.Export(match[1])
+		return
+	}
+	if match := jumpReg.FindStringSubmatch(text); match != nil {
+		board.JumpToResult(match[1])
+		return
+	}
+	if match := awayReg.FindStringSubmatch(text); match != nil {
+		board.Connection.SetAway(true, strings.TrimSpace(match[1]))
+		return
+	}
 	switch text {
 	case "/help":
 		board.ListCommands()
 	case "/disconnect":
+		board.SaveCache()
 		board.Connection.Disconnect()
+	case "/thread":
+		board.CloseThread()
+	case "/mentions":
+		board.Connection.RequestMentions()
+	case "/more":
+		board.SearchMore()
+	case "/who":
+		board.ShowOnlineUsers()
+	case "/back":
+		board.Connection.SetAway(false, "")
 	default:
 		message := &server.Message{
 			Content:  text,
-			AuthorID: board.Connection.AssignID,
+			AuthorID: board.Connection.AssignID(),
 		}
-		if err := utils.WriteToUDPConn(board.Connection.conn, utils.AddMessageCommand, message); err != nil {
+		if err := board.Connection.Write(utils.AddMessageCommand, message); err != nil {
 			return
 		}
 	}
@@ -132,6 +348,86 @@ func (board *MessageBoard) ListCommands() {
 		Action:      "delete",
 		Description: "delete message by tag (/delete T1)",
 		Prefix:      "/",
+	}, {
+		Action:      "reply",
+		Description: "replies to a message by tag (/reply T1 sounds good)",
+		Prefix:      "/",
+	}, {
+		Action:      "react",
+		Description: "reacts to a message by tag (/react T1 :thumbsup:)",
+		Prefix:      "/",
+	}, {
+		Action:      "unreact",
+		Description: "removes your reaction (/unreact T1 :thumbsup:)",
+		Prefix:      "/",
+	}, {
+		Action:      "thread",
+		Description: "shows only the conversation of a message (/thread T1), /thread alone shows all messages again",
+		Prefix:      "/",
+	}, {
+		Action:      "mentions",
+		Description: "lists recent messages mentioning you, including the ones sent while you were offline",
+		Prefix:      "/",
+	}, {
+		Action:      "search",
+		Description: "searches messages with optional from:bob, before:2024-01-31, after:2024-01-01 and in:#general filters (/search deploy from:bob)",
+		Prefix:      "/",
+	}, {
+		Action:      "jump",
+		Description: "shows a search result in context (/jump R1), /more lists older results",
+		Prefix:      "/",
+	}, {
+		Action:      "export",
+		Description: "saves loaded messages as JSON Lines, or a transcript for .txt and .md files (/export chat.md)",
+		Prefix:      "/",
+	}, {
+		Action:      "nick",
+		Description: "changes your nickname (/nick alice)",
+		Prefix:      "/",
+	}, {
+		Action:      "who",
+		Description: "lists online users",
+		Prefix:      "/",
+	}, {
+		Action:      "whois",
+		Description: "shows user details (/whois bob)",
+		Prefix:      "/",
+	}, {
+		Action:      "away",
+		Description: "marks you as away with an optional message (/away lunch)",
+		Prefix:      "/",
+	}, {
+		Action:      "back",
+		Description: "marks you as back",
+		Prefix:      "/",
+	}, {
+		Action:      "kick",
+		Description: "moderators only, disconnects a user (/kick bob [reason])",
+		Prefix:      "/",
+	}, {
+		Action:      "ban",
+		Description: "moderators only, bans a user account and IP (/ban bob [1h] [reason])",
+		Prefix:      "/",
+	}, {
+		Action:      "unban",
+		Description: "moderators only, lifts a ban (/unban bob)",
+		Prefix:      "/",
+	}, {
+		Action:      "mute",
+		Description: "moderators only, stops a user from sending messages (/mute bob [10m] [reason])",
+		Prefix:      "/",
+	}, {
+		Action:      "unmute",
+		Description: "moderators only, lifts a mute (/unmute bob)",
+		Prefix:      "/",
+	}, {
+		Action:      "promote",
+		Description: "owner only, makes a user moderator (/promote bob)",
+		Prefix:      "/",
+	}, {
+		Action:      "demote",
+		Description: "owner only, makes a moderator a regular member (/demote bob)",
+		Prefix:      "/",
 	}}
 
 	arrowsOptionsList := []Option{
@@ -159,50 +455,163 @@ func (board *MessageBoard) GenerateMessageLog(message *server.Message) []interfa
 	date := message.CreatedAt.Format("Jan 2 15:04:05")
 	info := fmt.Sprintf("[grey]%s[::-]", date)
 
-	authorName := message.AuthorName
-	if message.AuthorID == board.Connection.AssignID {
+	// user text is escaped so it is never read as color or region tags
+	authorName := tview.Escape(message.AuthorName)
+	if message.AuthorID == board.Connection.AssignID() {
 		authorName = fmt.Sprintf("[blue::b]%s[::-]", authorName)
-		clientMessagesLength := len(board.ClientMessages)
-		tag := fmt.Sprintf("T%d", clientMessagesLength+1)
-		board.ClientMessages[tag] = message
-		info = fmt.Sprintf("%s [blue]%s[::-]", info, tag)
 	}
-	return []interface{}{authorName, " ", info, "\n", "  [white]", message.Content, "[::-]\n\n"}
+	content := tview.Escape(message.Content)
+	if board.IsMentioned(message) {
+		authorName = "[yellow::b]▌[::-]" + authorName
+		content = board.HighlightMentions(content)
+	}
+	// every message is tagged so it can be replied to
+	tag := board.Tag(message)
+	board.ClientMessages[tag] = message
+	info = fmt.Sprintf("%s [blue]%s[::-]", info, tag)
+
+	quote := ""
+	if message.ReplyTo != "" {
+		quote = board.GenerateQuoteLog(message)
+	}
+	reactions := GenerateReactionsLog(message.Reactions, board.Connection.Username())
+	// messages are regions so search results can be highlighted
+	region := fmt.Sprintf(`["%s"]`, message.ID)
+	return []interface{}{region, authorName, " ", info, "\n", quote, "  [white]", content, "[::-]\n", reactions, `[""]`, "\n"}
 }
 
 func (board *MessageBoard) HandleDeleteMessageByTag(tag string) {
+	board.mu.Lock()
 	message, ok := board.ClientMessages[tag]
+	board.mu.Unlock()
 	if !ok {
 		board.Connection.LogError(fmt.Errorf("message \"%s\" doesnt exist", tag))
 		return
 	}
-	if message.AuthorID == "" {
+	if message.AuthorID == "" && !board.Connection.IsModerator() {
 		board.Connection.LogError(fmt.Errorf("cannot delete unowned message"))
 		return
 	}
 	board.Connection.DeleteMessage(message)
 }
 
+// HandleModeration sends a moderation action, ban and mute accept an optional leading duration before the reason.
+func (board *MessageBoard) HandleModeration(action string, target string, args string) {
+	input := &server.ModerationInput{Action: action, Target: target}
+	args = strings.TrimSpace(args)
+	if action == server.BanAction || action == server.MuteAction {
+		fields := strings.SplitN(args, " ", 2)
+		if _, err := time.ParseDuration(fields[0]); err == nil {
+			input.Duration = fields[0]
+			args = ""
+			if len(fields) == 2 {
+				args = fields[1]
+			}
+		}
+	}
+	input.Reason = args
+	board.Connection.Moderate(input)
+}
+
 func (board *MessageBoard) ListenToMessageDeletion() {
 	for msgId := range board.Connection.MessageDeleteChan {
-		board.ClientMessages = map[string]*server.Message{}
+		board.mu.Lock()
 		newStore := make([]*server.Message, 0)
-		newStoreLog := make([]interface{}, 0)
-
 		for _, message := range board.Store {
 			msg := message
 			if msg.ID != msgId {
 				newStore = append(newStore, msg)
-				formattedMessage := board.GenerateMessageLog(msg)
-				newStoreLog = append(newStoreLog, formattedMessage...)
 			}
 		}
 		board.Store = newStore
-		updatedText := ""
-		for _, str := range newStoreLog {
-			s := str.(string)
-			updatedText += s
+		board.cacheDirty = true
+		board.Rerender()
+		board.mu.Unlock()
+	}
+}
+
+func (board *MessageBoard) HandleReactionByTag(tag string, emoji string, add bool) {
+	board.mu.Lock()
+	message, ok := board.ClientMessages[tag]
+	board.mu.Unlock()
+	if !ok {
+		board.Connection.LogError(fmt.Errorf("message \"%s\" doesnt exist", tag))
+		return
+	}
+	board.Connection.React(message.ID, emoji, add)
+}
+
+func (board *MessageBoard) ListenToReactions() {
+	for update := range board.Connection.ReactionChan {
+		board.mu.Lock()
+		message := board.FindMessage(update.MessageID)
+		if message == nil {
+			board.mu.Unlock()
+			continue
+		}
+		if message.Reactions == nil {
+			message.Reactions = map[string][]string{}
+		}
+		names := make([]string, 0, len(message.Reactions[update.Emoji])+1)
+		for _, name := range message.Reactions[update.Emoji] {
+			if name != update.Name {
+				names = append(names, name)
+			}
 		}
-		board.View.SetText(updatedText)
+		if update.Added {
+			names = append(names, update.Name)
+		}
+		message.Reactions[update.Emoji] = names
+		board.cacheDirty = true
+		if len(names) == 0 {
+			delete(message.Reactions, update.Emoji)
+		}
+		board.Rerender()
+		board.mu.Unlock()
+	}
+}
+
+// RenameAuthor shows messages of a renamed user under the new name.
+func (board *MessageBoard) RenameAuthor(oldName string, newName string) {
+	board.mu.Lock()
+	defer board.mu.Unlock()
+	for _, message := range board.Store {
+		if message.AuthorName == oldName {
+			message.AuthorName = newName
+		}
+		for _, names := range message.Reactions {
+			for i, name := range names {
+				if name == oldName {
+					names[i] = newName
+				}
+			}
+		}
+	}
+	board.Rerender()
+}
+
+// Rerender rebuilds the message view from the store, callers must hold the board lock.
+func (board *MessageBoard) Rerender() {
+	board.ClientMessages = map[string]*server.Message{}
+	var text strings.Builder
+	for _, message := range board.Store {
+		if !board.IsVisible(message) {
+			continue
+		}
+		for _, str := range append(board.GenerateUnreadDivider(message), board.GenerateMessageLog(message)...) {
+			text.WriteString(str.(string))
+		}
+	}
+	board.View.SetText(text.String())
+}
+
+// Tag returns the tag of a message, numbered the first time the message is shown so a tag
+// keeps pointing at the same message when older history or a thread is rendered.
+func (board *MessageBoard) Tag(message *server.Message) string {
+	tag, ok := board.tags[message.ID]
+	if !ok {
+		tag = fmt.Sprintf("T%d", len(board.tags)+1)
+		board.tags[message.ID] = tag
 	}
+	return tag
 }
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/server"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"github.com/rivo/tview"
+	"regexp"
+)
+
+var searchReg = regexp.MustCompile(`^/search (.+)$`)
+var jumpReg = regexp.MustCompile(`^/jump (R\d+)$`)
+
+// Search asks the server for a page of messages matching query, skipping the first offset results.
+func (c *Connection) Search(query string, offset int) {
+	input := &server.SearchInput{ClientID: c.AssignID(), Query: query, Offset: offset}
+	if err := c.Write(utils.SearchCommand, input); err != nil {
+		c.LogError(fmt.Errorf("could not send search: %s", err))
+	}
+}
+
+func (c *Connection) HandleSearch(data []byte) {
+	var results server.SearchResults
+	if err := json.Unmarshal(data, &results); err != nil {
+		c.LogError(fmt.Errorf("failed to unmarshal search results"))
+		return
+	}
+	if results.Error != "" {
+		c.LogError(fmt.Errorf("%s", results.Error))
+		return
+	}
+	c.SearchChan <- &results
+}
+
+// SearchMore requests the next page of the last search.
+func (board *MessageBoard) SearchMore() {
+	board.mu.Lock()
+	last := board.LastSearch
+	board.mu.Unlock()
+	if last == nil || !last.HasMore {
+		go board.Connection.LogError(fmt.Errorf("no more search results"))
+		return
+	}
+	board.Connection.Search(last.Query, last.Offset+len(last.Messages))
+}
+
+// ListenToSearch lists search results tagged so they can be jumped to.
+func (board *MessageBoard) ListenToSearch() {
+	for results := range board.Connection.SearchChan {
+		board.mu.Lock()
+		board.LastSearch = results
+		board.SearchResults = map[string]*server.Message{}
+		first := results.Offset + 1
+		text := fmt.Sprintf("[lightgrey::b]Search \"%s\" (%d-%d of %d)[::-]\n", tview.Escape(results.Query), first, first+len(results.Messages)-1, results.Total)
+		if results.Total == 0 {
+			text = fmt.Sprintf("[lightgrey::b]Search \"%s\" found nothing[::-]\n", tview.Escape(results.Query))
+		}
+		for i, message := range results.Messages {
+			tag := fmt.Sprintf("R%d", i+1)
+			board.SearchResults[tag] = message
+			date := message.CreatedAt.Format("Jan 2 15:04:05")
+			text += fmt.Sprintf("  [blue]%s[::-] [grey]%s[::-] [white::b]%s[::-] %s\n", tag, date, tview.Escape(message.AuthorName), tview.Escape(message.Content))
+		}
+		if results.HasMore {
+			text += "  [grey]/more shows older results[::-]\n"
+		}
+		board.mu.Unlock()
+		board.StreamToMessageView(text, "\n")
+	}
+}
+
+// JumpToResult shows a search result in context, loading older history until the message is found.
+func (board *MessageBoard) JumpToResult(tag string) {
+	board.mu.Lock()
+	defer board.mu.Unlock()
+	message, ok := board.SearchResults[tag]
+	if !ok {
+		go board.Connection.LogError(fmt.Errorf("search result \"%s\" doesnt exist", tag))
+		return
+	}
+	board.jumpTarget = message.ID
+	board.JumpToTarget()
+}
+
+// JumpToTarget highlights the jump target once it is loaded, callers must hold the board lock.
+func (board *MessageBoard) JumpToTarget() {
+	if board.jumpTarget == "" {
+		return
+	}
+	message := board.FindMessage(board.jumpTarget)
+	if message == nil {
+		if board.historyStart {
+			board.jumpTarget = ""
+			go board.Connection.LogError(fmt.Errorf("message no longer exists"))
+			return
+		}
+		board.requestOlderHistory()
+		return
+	}
+	board.jumpTarget = ""
+	if !board.IsVisible(message) {
+		board.ThreadRoot = ""
+		board.Frame.SetTitle(board.Title())
+	}
+	board.Rerender()
+	board.View.Highlight(message.ID).ScrollToHighlight()
+}
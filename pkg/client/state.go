@@ -0,0 +1,143 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// State is a step of the connection lifecycle, the run loop is the only goroutine changing it.
+type State string
+
+const (
+	Connecting   State = "connecting"   // registration sent, waiting for the initial payload
+	Syncing      State = "syncing"      // receiving the initial history, live packets are queued
+	Live         State = "live"         // initial history is complete, packets are applied as they come
+	Reconnecting State = "reconnecting" // server stopped answering, registering again
+	Closed       State = "closed"       // disconnected or kicked, packets are ignored
+)
+
+const (
+	DefaultConnectTimeout = 2 * time.Second  // wait for the initial payload before registering again
+	ConnectRetries        = 3                // registrations before the server is reported unreachable
+	MaxReconnectDelay     = 30 * time.Second // cap of the registration backoff
+)
+
+func (c *Connection) State() State {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return c.state
+}
+
+// SetState moves the connection to state and notifies StateChan without blocking.
+func (c *Connection) SetState(state State) {
+	c.stateMu.Lock()
+	changed := c.state != state
+	c.state = state
+	c.stateMu.Unlock()
+	if !changed {
+		return
+	}
+	select {
+	case c.StateChan <- state:
+	default:
+	}
+}
+
+// Run applies packets, read errors and timeouts one at a time until the connection is closed,
+// so connection state needs no locking.
+func (c *Connection) Run() {
+	for {
+		select {
+		case msg := <-c.packets:
+			c.HandleUDPMessage(msg)
+		case err := <-c.readErrors:
+			c.HandleReadError(err)
+		case before := <-c.pageRequests:
+			c.StartHistoryPage(before)
+		case <-c.timer.C:
+			c.HandleTimeout()
+		case <-c.done:
+			c.timer.Stop()
+			return
+		}
+	}
+}
+
+// resetTimer schedules the next timeout of the current state.
+func (c *Connection) resetTimer(d time.Duration) {
+	if !c.timer.Stop() {
+		select {
+		case <-c.timer.C:
+		default:
+		}
+	}
+	c.timer.Reset(d)
+}
+
+func (c *Connection) HandleTimeout() {
+	switch c.State() {
+	case Connecting, Reconnecting:
+		c.attempts += 1
+		if c.attempts == ConnectRetries && c.State() == Connecting {
+			c.SetState(Reconnecting)
+			c.LogError(fmt.Errorf("server %s is not answering, still trying to connect", c.ServerAddress))
+		}
+		c.early = nil
+		c.RegisterClient(c.username)
+		c.resetTimer(c.backoff())
+	case Syncing:
+		c.HandleSyncTimeout()
+	case Live:
+		c.HandlePageTimeout()
+	}
+}
+
+// HandleReadError closes the connection when the socket is gone, other errors mean the server
+// could not be reached and the client registers again.
+func (c *Connection) HandleReadError(err error) {
+	if errors.Is(err, net.ErrClosed) {
+		c.SetState(Closed)
+		return
+	}
+	state := c.State()
+	if state == Closed || state == Reconnecting {
+		return
+	}
+	c.Reconnect()
+}
+
+// Reconnect registers again with the session id, the new initial history replaces the current one.
+func (c *Connection) Reconnect() {
+	c.SetState(Reconnecting)
+	c.cache = nil // the board holds newer messages than the cache, ask for the full history
+	c.attempts = 0
+	c.early = nil
+	c.queue = nil
+	c.page = nil // the new initial history replaces the board, older pages are requested again
+	c.resetTimer(c.backoff())
+}
+
+// backoff doubles the wait between registrations up to MaxReconnectDelay.
+func (c *Connection) backoff() time.Duration {
+	delay := c.ConnectTimeout
+	for i := 0; i < c.attempts && delay < MaxReconnectDelay; i++ {
+		delay *= 2
+	}
+	if delay > MaxReconnectDelay {
+		delay = MaxReconnectDelay
+	}
+	return delay
+}
+
+// Close stops the run loop and closes the socket, it is safe to call more than once.
+func (c *Connection) Close() {
+	c.closeOnce.Do(func() {
+		c.SetState(Closed)
+		close(c.done)
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/server"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"time"
+)
+
+const (
+	HistorySyncTimeout = 2 * time.Second // wait without new history logs before missing ones are requested again
+	HistorySyncRetries = 3               // requests for missing logs before showing partial history
+)
+
+// MissingOrders returns the orders of initial history logs not received yet.
+func (c *Connection) MissingOrders() []int {
+	missing := make([]int, 0)
+	for order, received := range c.received {
+		if !received {
+			missing = append(missing, order)
+		}
+	}
+	return missing
+}
+
+// HandleSyncTimeout requests lost history logs again when the initial sync stalls,
+// and shows partial history with a warning once retries run out instead of waiting forever.
+func (c *Connection) HandleSyncTimeout() {
+	if c.LocalHistoryLength != c.progress { // still receiving
+		c.progress = c.LocalHistoryLength
+		c.resetTimer(c.SyncTimeout)
+		return
+	}
+	missing := c.MissingOrders()
+	if c.attempts == c.SyncRetries {
+		c.LogError(fmt.Errorf("history is incomplete, %d of %d messages could not be loaded", len(missing), len(c.InitialHistory)))
+		c.CompleteHistory()
+		return
+	}
+	c.attempts += 1
+	c.RequestMissingHistory(missing)
+	c.resetTimer(c.SyncTimeout)
+}
+
+// RequestMissingHistory asks the server to resend the given initial history logs.
+func (c *Connection) RequestMissingHistory(orders []int) {
+	input := &server.ResendHistoryInput{ClientID: c.AssignID(), Orders: orders}
+	if err := c.Write(utils.ResendHistoryCommand, input); err != nil {
+		c.LogError(fmt.Errorf("could not request missing history: %s", err))
+	}
+}
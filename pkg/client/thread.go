@@ -0,0 +1,119 @@
+package client
+
+import (
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/server"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"github.com/rivo/tview"
+	"strings"
+)
+
+const (
+	DefaultTitle  = "[#Cocus chat]"
+	QuoteMaxWidth = 40
+)
+
+// Title returns the frame title for the current view and connection state.
+func (board *MessageBoard) Title() string {
+	title := DefaultTitle
+	if board.ThreadRoot != "" {
+		title += "[thread]"
+	}
+	switch state := board.Connection.State(); state {
+	case Live:
+	case Closed:
+		title += "[disconnected]"
+	default:
+		title += fmt.Sprintf("[%s…]", state)
+	}
+	return title
+}
+
+// FindMessage looks up a loaded message by id, callers must hold the board lock.
+func (board *MessageBoard) FindMessage(id string) *server.Message {
+	for _, message := range board.Store {
+		if message.ID == id {
+			return message
+		}
+	}
+	return nil
+}
+
+// ThreadRootOf follows replies up to the first message of the conversation, a parent that is not
+// loaded is used as the root so its replies are still grouped. Callers must hold the board lock.
+func (board *MessageBoard) ThreadRootOf(message *server.Message) string {
+	current := message
+	for visited := 0; current.ReplyTo != "" && visited < len(board.Store); visited++ {
+		parent := board.FindMessage(current.ReplyTo)
+		if parent == nil {
+			return current.ReplyTo
+		}
+		current = parent
+	}
+	return current.ID
+}
+
+// IsVisible reports whether message belongs to the opened thread, all messages are visible outside
+// of thread view. Callers must hold the board lock.
+func (board *MessageBoard) IsVisible(message *server.Message) bool {
+	return board.ThreadRoot == "" || board.ThreadRootOf(message) == board.ThreadRoot
+}
+
+// GenerateQuoteLog renders a snippet of the replied to message above the reply.
+func (board *MessageBoard) GenerateQuoteLog(message *server.Message) string {
+	parent := board.FindMessage(message.ReplyTo)
+	if parent == nil {
+		return "  [grey]│ reply to a message that is not loaded[::-]\n"
+	}
+	snippet := strings.ReplaceAll(parent.Content, "\n", " ")
+	if runes := []rune(snippet); len(runes) > QuoteMaxWidth {
+		snippet = string(runes[:QuoteMaxWidth]) + "…"
+	}
+	return fmt.Sprintf("  [grey]│ %s: %s[::-]\n", tview.Escape(parent.AuthorName), tview.Escape(snippet))
+}
+
+func (board *MessageBoard) Reply(tag string, text string) {
+	board.mu.Lock()
+	parent, ok := board.ClientMessages[tag]
+	board.mu.Unlock()
+	if !ok {
+		board.Connection.LogError(fmt.Errorf("message \"%s\" doesnt exist", tag))
+		return
+	}
+	message := &server.Message{
+		Content:  text,
+		AuthorID: board.Connection.AssignID(),
+		ReplyTo:  parent.ID,
+	}
+	if err := board.Connection.Write(utils.AddMessageCommand, message); err != nil {
+		board.Connection.LogError(fmt.Errorf("could not send reply: %s", err))
+	}
+}
+
+// OpenThread filters the board to the conversation the tagged message belongs to.
+func (board *MessageBoard) OpenThread(tag string) {
+	board.mu.Lock()
+	defer board.mu.Unlock()
+	message, ok := board.ClientMessages[tag]
+	if !ok {
+		go board.Connection.LogError(fmt.Errorf("message \"%s\" doesnt exist", tag))
+		return
+	}
+	board.ThreadRoot = board.ThreadRootOf(message)
+	board.Frame.SetTitle(board.Title())
+	board.Rerender()
+	board.View.ScrollToBeginning()
+}
+
+// CloseThread shows all messages again.
+func (board *MessageBoard) CloseThread() {
+	board.mu.Lock()
+	defer board.mu.Unlock()
+	if board.ThreadRoot == "" {
+		return
+	}
+	board.ThreadRoot = ""
+	board.Frame.SetTitle(board.Title())
+	board.Rerender()
+	board.View.ScrollToEnd()
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TypingThrottle = 3 * time.Second // minimum delay between repeated typing-start events
+	TypingIdle     = 4 * time.Second // delay without edits before typing-stop is sent
+	TypingExpiry   = 6 * time.Second // typing indicator is dropped if no event refreshes it
+)
+
+// TypingIndicator shows who is typing in the message board frame footer.
+type TypingIndicator struct {
+	mu         sync.Mutex
+	Frame      *tview.Frame
+	Connection *Connection
+	app        *tview.Application
+	typing     map[string]time.Time
+}
+
+func NewTypingIndicator(app *tview.Application, connection *Connection, frame *tview.Frame) *TypingIndicator {
+	indicator := &TypingIndicator{
+		Frame:      frame,
+		Connection: connection,
+		app:        app,
+		typing:     map[string]time.Time{},
+	}
+	go indicator.ListenToTyping()
+	return indicator
+}
+
+// ListenToTyping applies typing events and expires indicators whose stop event was lost.
+func (indicator *TypingIndicator) ListenToTyping() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case event := <-indicator.Connection.TypingChan:
+			indicator.mu.Lock()
+			if event.Typing {
+				indicator.typing[event.Name] = time.Now().Add(TypingExpiry)
+			} else {
+				delete(indicator.typing, event.Name)
+			}
+			indicator.mu.Unlock()
+		case now := <-ticker.C:
+			indicator.mu.Lock()
+			expired := false
+			for name, expiry := range indicator.typing {
+				if now.After(expiry) {
+					delete(indicator.typing, name)
+					expired = true
+				}
+			}
+			indicator.mu.Unlock()
+			if !expired {
+				continue
+			}
+		}
+		indicator.Render()
+	}
+}
+
+func (indicator *TypingIndicator) Render() {
+	indicator.mu.Lock()
+	names := make([]string, 0, len(indicator.typing))
+	for name := range indicator.typing {
+		names = append(names, name)
+	}
+	indicator.mu.Unlock()
+	sort.Strings(names)
+	text := tview.Escape(FormatTyping(names))
+	indicator.app.QueueUpdateDraw(func() {
+		indicator.Frame.Clear()
+		if text != "" {
+			indicator.Frame.AddText(text, false, tview.AlignLeft, tcell.ColorGrey)
+		}
+	})
+}
+
+// FormatTyping builds the "alice and bob are typing…" footer text.
+func FormatTyping(names []string) string {
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("%s is typing…", names[0])
+	case 2, 3:
+		return fmt.Sprintf("%s and %s are typing…", strings.Join(names[:len(names)-1], ", "), names[len(names)-1])
+	default:
+		return "several people are typing…"
+	}
+}
+
+// TypingNotifier throttles typing events sent while the user edits the input field.
+type TypingNotifier struct {
+	mu         sync.Mutex
+	Connection *Connection
+	typing     bool
+	lastSent   time.Time
+	idleTimer  *time.Timer
+}
+
+// Changed is called on each input edit.
+func (notifier *TypingNotifier) Changed(text string) {
+	if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "/") { // commands are not messages
+		notifier.Stop()
+		return
+	}
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+	if !notifier.typing || time.Since(notifier.lastSent) > TypingThrottle {
+		notifier.typing = true
+		notifier.lastSent = time.Now()
+		go notifier.Connection.SendTyping(true)
+	}
+	if notifier.idleTimer != nil {
+		notifier.idleTimer.Stop()
+	}
+	notifier.idleTimer = time.AfterFunc(TypingIdle, notifier.Stop)
+}
+
+// Stop sends typing-stop if typing-start was sent.
+func (notifier *TypingNotifier) Stop() {
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+	if notifier.idleTimer != nil {
+		notifier.idleTimer.Stop()
+		notifier.idleTimer = nil
+	}
+	if !notifier.typing {
+		return
+	}
+	notifier.typing = false
+	go notifier.Connection.SendTyping(false)
+}
@@ -2,6 +2,7 @@ package client
 
 import (
 	"github.com/gdamore/tcell/v2"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
 	"github.com/rivo/tview"
 )
 
@@ -10,15 +11,22 @@ const (
 	InputView   = "input_view"
 )
 
-func NewUDPClient() (*tview.Application, error) {
+func NewUDPClient(capture *utils.Capture) (*tview.Application, error) {
 	app := tview.NewApplication()
 	connection := NewConnection(app)
+	connection.Capture = capture
 	messageBoard := NewMessageBoard(app, connection)
+	userList := NewUserList(app, connection, messageBoard)
+	NewTypingIndicator(app, connection, messageBoard.Frame)
 	inputSection := NewInputSection(messageBoard)
 
+	contentFlex := tview.NewFlex()
+	contentFlex.AddItem(messageBoard.Frame, 0, 1, false)
+	contentFlex.AddItem(userList.Frame, 24, 0, false)
+
 	mainFlex := tview.NewFlex()
 	mainFlex.SetDirection(tview.FlexRow)
-	mainFlex.AddItem(messageBoard.Frame, 0, 1, false)
+	mainFlex.AddItem(contentFlex, 0, 1, false)
 	mainFlex.AddItem(inputSection.View, 2, 1, false)
 
 	// initial connection form
@@ -0,0 +1,40 @@
+package client
+
+import (
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/server"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+)
+
+// MarkRead moves the server side read cursor forward to the given message.
+func (c *Connection) MarkRead(messageID string) {
+	if c.AssignID() == "" || messageID == "" {
+		return
+	}
+	input := &server.ReadInput{ClientID: c.AssignID(), MessageID: messageID}
+	if err := c.Write(utils.MarkReadCommand, input); err != nil {
+		c.LogError(fmt.Errorf("could not mark messages as read: %s", err))
+	}
+}
+
+// FindFirstUnread returns the id of the first message missed while offline, callers must hold the board lock.
+func (board *MessageBoard) FindFirstUnread(history []*server.Message) string {
+	unread := board.Connection.UnreadCount
+	if unread <= 0 || unread > len(history) {
+		return ""
+	}
+	return history[len(history)-unread].ID
+}
+
+// GenerateUnreadDivider separates messages already read from the ones missed while offline.
+func (board *MessageBoard) GenerateUnreadDivider(message *server.Message) []interface{} {
+	if board.FirstUnread == "" || message.ID != board.FirstUnread {
+		return nil
+	}
+	count := board.Connection.UnreadCount
+	label := "message"
+	if count > 1 {
+		label += "s"
+	}
+	return []interface{}{fmt.Sprintf("[red]──── %d unread %s ────[::-]\n\n", count, label)}
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/server"
+	"github.com/rivo/tview"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type UserList struct {
+	View         *tview.TextView
+	Frame        *tview.Frame
+	mu           sync.RWMutex // guards Users, written by the presence listener and read by the UI
+	Users        map[string]*server.RosterEntry
+	Connection   *Connection
+	MessageBoard *MessageBoard
+}
+
+func NewUserList(app *tview.Application, connection *Connection, messageBoard *MessageBoard) *UserList {
+	userView := tview.NewTextView().SetChangedFunc(func() {
+		app.Draw()
+	})
+	userView.SetDynamicColors(true).SetScrollable(true)
+
+	userFrame := tview.NewFrame(userView)
+	userFrame.SetTitle("[Online]").SetBorder(true).SetTitleAlign(0)
+
+	userList := &UserList{
+		View:         userView,
+		Frame:        userFrame,
+		Users:        map[string]*server.RosterEntry{},
+		Connection:   connection,
+		MessageBoard: messageBoard,
+	}
+	messageBoard.UserList = userList
+
+	go userList.ListenToPresence()
+	return userList
+}
+
+// ListenToPresence keeps the online list in sync with the initial roster and live presence events.
+func (list *UserList) ListenToPresence() {
+	for {
+		select {
+		case roster := <-list.Connection.RosterChan:
+			users := make(map[string]*server.RosterEntry, len(roster))
+			for _, entry := range roster {
+				users[entry.Name] = entry
+			}
+			list.mu.Lock()
+			list.Users = users
+			list.mu.Unlock()
+		case event := <-list.Connection.PresenceChan:
+			list.ApplyPresence(event)
+			list.MessageBoard.ShowPresence(event)
+		}
+		list.Render()
+	}
+}
+
+func (list *UserList) ApplyPresence(event *server.PresenceEvent) {
+	list.mu.Lock()
+	defer list.mu.Unlock()
+	switch event.Type {
+	case server.JoinPresence:
+		list.Users[event.Name] = &server.RosterEntry{Name: event.Name, Role: event.Role, Status: server.OnlineStatus}
+	case server.LeavePresence:
+		delete(list.Users, event.Name)
+	case server.RenamePresence:
+		entry, ok := list.Users[event.OldName]
+		if !ok {
+			entry = &server.RosterEntry{Role: event.Role, Status: server.OnlineStatus}
+		}
+		delete(list.Users, event.OldName)
+		entry.Name = event.Name
+		list.Users[event.Name] = entry
+	case server.AwayPresence, server.BackPresence:
+		entry, ok := list.Users[event.Name]
+		if !ok {
+			entry = &server.RosterEntry{Name: event.Name, Role: event.Role}
+			list.Users[event.Name] = entry
+		}
+		entry.Status = server.OnlineStatus
+		entry.AwayMessage = ""
+		if event.Type == server.AwayPresence {
+			entry.Status = server.AwayStatus
+			entry.AwayMessage = event.Message
+		}
+	}
+}
+
+// Entries returns copies of online users sorted by name, safe to read while presence events arrive.
+func (list *UserList) Entries() []*server.RosterEntry {
+	list.mu.RLock()
+	entries := make([]*server.RosterEntry, 0, len(list.Users))
+	for _, entry := range list.Users {
+		copied := *entry
+		entries = append(entries, &copied)
+	}
+	list.mu.RUnlock()
+	sort.Slice(entries, func(i, j int) bool { return strings.ToLower(entries[i].Name) < strings.ToLower(entries[j].Name) })
+	return entries
+}
+
+func (list *UserList) Render() {
+	text := ""
+	for _, entry := range list.Entries() {
+		text += FormatRosterEntry(entry) + "\n"
+	}
+	list.View.SetText(text)
+}
+
+func FormatRosterEntry(entry *server.RosterEntry) string {
+	color := "green"
+	if entry.Status == server.AwayStatus {
+		color = "yellow"
+	}
+	role := ""
+	switch entry.Role {
+	case server.RoleOwner:
+		role = "~"
+	case server.RoleModerator:
+		role = "@"
+	}
+	return fmt.Sprintf("[%s]●[::-] %s%s", color, role, tview.Escape(entry.Name))
+}
@@ -0,0 +1,212 @@
+// Package netsim provides a UDP proxy impairing traffic between clients and a server,
+// so tests can exercise loss, duplication, delay, reordering and corruption over loopback.
+package netsim
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// ReorderHold is the longest a packet is held back waiting for another one to overtake it.
+const ReorderHold = 50 * time.Millisecond
+
+// Impairment describes what happens to packets going in one direction, probabilities are between 0 and 1.
+type Impairment struct {
+	Drop      float64
+	Duplicate float64
+	Reorder   float64 // packet is held back until the next one is sent
+	Corrupt   float64 // one byte of the payload after the command is flipped, the command is left intact
+	Delay     time.Duration
+	Jitter    time.Duration // random extra delay up to Jitter, also reorders packets
+}
+
+// Proxy forwards packets between clients and a server, each client gets its own socket towards the server
+// so the server still sees one address per client.
+type Proxy struct {
+	conn       *net.UDPConn
+	server     *net.UDPAddr
+	mu         sync.Mutex
+	upstream   Impairment // client to server
+	downstream Impairment // server to client
+	random     *rand.Rand
+	sessions   map[string]*session
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// session is the link of one client to the server.
+type session struct {
+	client *net.UDPAddr
+	conn   *net.UDPConn
+	held   [2][]byte // packet held back for reordering, by direction
+}
+
+const (
+	upstream = iota
+	downstream
+)
+
+// NewProxy listens on a random localhost port and forwards to serverAddress, seed makes impairments repeatable.
+func NewProxy(serverAddress string, seed int64) (*Proxy, error) {
+	server, err := net.ResolveUDPAddr("udp", serverAddress)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{
+		conn:     conn,
+		server:   server,
+		random:   rand.New(rand.NewSource(seed)),
+		sessions: map[string]*session{},
+		done:     make(chan struct{}),
+	}
+	go p.Listen()
+	return p, nil
+}
+
+// Addr is the address clients connect to instead of the server.
+func (p *Proxy) Addr() net.Addr {
+	return p.conn.LocalAddr()
+}
+
+func (p *Proxy) Listen() {
+	buffer := make([]byte, 65535)
+	for {
+		n, addr, err := p.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		s, err := p.session(addr)
+		if err != nil {
+			continue
+		}
+		packet := append([]byte{}, buffer[:n]...)
+		p.forward(s, upstream, packet, func(packet []byte) { s.conn.Write(packet) })
+	}
+}
+
+// session returns the link of client, creating it and forwarding server replies on first use.
+func (p *Proxy) session(client *net.UDPAddr) (*session, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.sessions[client.String()]; ok {
+		return s, nil
+	}
+	conn, err := net.DialUDP("udp", nil, p.server)
+	if err != nil {
+		return nil, err
+	}
+	s := &session{client: client, conn: conn}
+	p.sessions[client.String()] = s
+	go func() {
+		buffer := make([]byte, 65535)
+		for {
+			n, err := conn.Read(buffer)
+			if err != nil {
+				select {
+				case <-p.done:
+					return
+				default:
+					continue // refused while the server is down
+				}
+			}
+			packet := append([]byte{}, buffer[:n]...)
+			p.forward(s, downstream, packet, func(packet []byte) { p.conn.WriteToUDP(packet, s.client) })
+		}
+	}()
+	return s, nil
+}
+
+// Impair sets the impairments applied from now on to packets sent to the server and to clients.
+func (p *Proxy) Impair(upstream Impairment, downstream Impairment) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.upstream, p.downstream = upstream, downstream
+}
+
+// forward applies the impairment of direction to packet before handing it to send.
+func (p *Proxy) forward(s *session, direction int, packet []byte, send func([]byte)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	impairment := p.upstream
+	if direction == downstream {
+		impairment = p.downstream
+	}
+	if p.chance(impairment.Drop) {
+		return
+	}
+	copies := 1
+	if p.chance(impairment.Duplicate) {
+		copies = 2
+	}
+	if p.chance(impairment.Corrupt) {
+		packet = p.corrupt(packet)
+	}
+	if s.held[direction] == nil && p.chance(impairment.Reorder) {
+		s.held[direction] = packet
+		time.AfterFunc(ReorderHold, func() { // nothing overtook it in time
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if held := s.held[direction]; held != nil && bytes.Equal(held, packet) {
+				s.held[direction] = nil
+				send(held)
+			}
+		})
+		return
+	}
+	packets := make([][]byte, 0, copies+1)
+	for i := 0; i < copies; i++ {
+		packets = append(packets, packet)
+	}
+	if held := s.held[direction]; held != nil {
+		packets = append(packets, held)
+		s.held[direction] = nil
+	}
+	for _, packet := range packets {
+		delay := impairment.Delay
+		if impairment.Jitter > 0 {
+			delay += time.Duration(p.random.Int63n(int64(impairment.Jitter)))
+		}
+		if delay == 0 {
+			send(packet)
+			continue
+		}
+		packet := packet
+		time.AfterFunc(delay, func() { send(packet) })
+	}
+}
+
+// chance reports whether an event of the given probability happens, callers must hold the lock.
+func (p *Proxy) chance(probability float64) bool {
+	return probability > 0 && p.random.Float64() < probability
+}
+
+// corrupt returns a copy of packet with one payload byte flipped, callers must hold the lock.
+func (p *Proxy) corrupt(packet []byte) []byte {
+	start := bytes.IndexByte(packet, '>') + 1
+	if start >= len(packet) {
+		return packet
+	}
+	corrupted := append([]byte{}, packet...)
+	i := start + p.random.Intn(len(packet)-start)
+	corrupted[i] ^= byte(1 << p.random.Intn(8))
+	return corrupted
+}
+
+func (p *Proxy) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.mu.Lock()
+		for _, s := range p.sessions {
+			s.conn.Close()
+		}
+		p.mu.Unlock()
+	})
+	return p.conn.Close()
+}
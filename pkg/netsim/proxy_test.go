@@ -0,0 +1,85 @@
+package netsim
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+// StartTestEcho starts a server sending every packet back.
+func StartTestEcho(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal("could not start echo server: ", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buffer := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(buffer[:n], addr)
+		}
+	}()
+	return conn
+}
+
+// SendTestPackets sends n packets through the proxy and returns the ones echoed back.
+func SendTestPackets(t *testing.T, upstream Impairment, downstream Impairment, n int) []string {
+	echo := StartTestEcho(t)
+	proxy, err := NewProxy(echo.LocalAddr().String(), 1)
+	if err != nil {
+		t.Fatal("could not start proxy: ", err)
+	}
+	defer proxy.Close()
+	proxy.Impair(upstream, downstream)
+	conn, err := net.DialUDP("udp", nil, proxy.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal("could not connect to proxy: ", err)
+	}
+	defer conn.Close()
+	for i := 0; i < n; i++ {
+		conn.Write([]byte("/message>" + string(rune('a'+i))))
+	}
+	received := make([]string, 0)
+	buffer := make([]byte, 65535)
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * ReorderHold))
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return received
+		}
+		received = append(received, string(buffer[:n]))
+	}
+}
+
+func TestProxy(t *testing.T) {
+	t.Run("Clean proxy forwards everything in order", func(t *testing.T) {
+		assert.Equal(t, []string{"/message>a", "/message>b", "/message>c"}, SendTestPackets(t, Impairment{}, Impairment{}, 3))
+	})
+
+	t.Run("Dropped packets never arrive", func(t *testing.T) {
+		assert.Empty(t, SendTestPackets(t, Impairment{}, Impairment{Drop: 1}, 3))
+	})
+
+	t.Run("Duplicated packets arrive twice each way", func(t *testing.T) {
+		assert.Len(t, SendTestPackets(t, Impairment{Duplicate: 1}, Impairment{Duplicate: 1}, 3), 12)
+	})
+
+	t.Run("Reordered packets are all delivered", func(t *testing.T) {
+		received := SendTestPackets(t, Impairment{Reorder: .5}, Impairment{}, 10)
+		assert.ElementsMatch(t, []string{"/message>a", "/message>b", "/message>c", "/message>d", "/message>e",
+			"/message>f", "/message>g", "/message>h", "/message>i", "/message>j"}, received)
+	})
+
+	t.Run("Corruption keeps the command", func(t *testing.T) {
+		sent := []string{"/message>a", "/message>b", "/message>c"}
+		for i, packet := range SendTestPackets(t, Impairment{Corrupt: 1}, Impairment{}, 3) {
+			assert.Regexp(t, "^/message>", packet)
+			assert.NotEqual(t, sent[i], packet)
+		}
+	})
+}
@@ -3,4 +3,5 @@ package server
 type HistoryLog struct {
 	Order   int      `json:"order"`
 	Message *Message `json:"message"`
+	Page    string   `json:"page,omitempty"` // before cursor of the requested page, empty for the initial history
 }
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	RoomRecord     = "room"
+	UserRecord     = "user"
+	MessageRecord  = "message"
+	DeletionRecord = "deletion"
+
+	JSONLFormat    = "jsonl"
+	TextFormat     = "text"
+	MarkdownFormat = "markdown"
+
+	transcriptDate = "2006-01-02 15:04:05"
+)
+
+// Archive is a portable copy of a chat used to back it up or migrate it to another server.
+type Archive struct {
+	Room      *Room        `json:"room,omitempty"`
+	Users     []*Client    `json:"users,omitempty"`
+	Messages  []*Message   `json:"messages,omitempty"`
+	Deletions []*Tombstone `json:"deletions,omitempty"`
+}
+
+// ArchiveRecord is a single line of an archive written as JSON Lines.
+type ArchiveRecord struct {
+	Type     string     `json:"type"`
+	Room     *Room      `json:"room,omitempty"`
+	User     *Client    `json:"user,omitempty"`
+	Message  *Message   `json:"message,omitempty"`
+	Deletion *Tombstone `json:"deletion,omitempty"`
+}
+
+// Export copies the room, users, history and deletions of the chat.
+func (chat *Chat) Export() *Archive {
+	chat.mu.RLock()
+	defer chat.mu.RUnlock()
+	room := *chat.Room
+	archive := &Archive{Room: &room}
+	for _, client := range chat.Clients {
+		archive.Users = append(archive.Users, client.Copy())
+	}
+	sort.Slice(archive.Users, func(i, j int) bool { return archive.Users[i].Name < archive.Users[j].Name })
+	for _, message := range chat.History {
+		msg := *message
+		archive.Messages = append(archive.Messages, &msg)
+	}
+	for _, tombstone := range chat.Deletions {
+		deletion := *tombstone
+		archive.Deletions = append(archive.Deletions, &deletion)
+	}
+	sort.Slice(archive.Deletions, func(i, j int) bool {
+		return archive.Deletions[i].DeletedAt.Before(archive.Deletions[j].DeletedAt)
+	})
+	return archive
+}
+
+// Import merges an archive into the chat, records already present are kept as they are
+// and messages deleted on either side stay deleted. The room configuration of the server is kept.
+// Archives holding content a client couldn't send are rejected before anything is written.
+func (chat *Chat) Import(archive *Archive) (string, error) {
+	imported := make([]*Message, 0, len(archive.Messages))
+	for _, message := range archive.Messages {
+		content, err := NormalizeContent(message.Content)
+		if err != nil {
+			return "", fmt.Errorf("invalid message \"%s\" in archive: %s", message.ID, err)
+		}
+		msg := *message
+		msg.Content = content
+		msg.AuthorName = ""
+		imported = append(imported, &msg)
+	}
+
+	chat.mu.Lock()
+	defer chat.mu.Unlock()
+	users := 0
+	for _, user := range archive.Users {
+		if user.ID == "" {
+			continue
+		}
+		if _, ok := chat.Clients[user.ID]; ok {
+			continue
+		}
+		client := user.Copy()
+		client.Online = false
+		client.Role = ImportedRole(client.Role)
+		client.Name = chat.UniqueNickname(client.Name)
+		if err := chat.SaveClientToRedis(client); err != nil {
+			return "", err
+		}
+		chat.Clients[client.ID] = client
+		users += 1
+	}
+	deletions := 0
+	for _, tombstone := range archive.Deletions {
+		if _, ok := chat.Deletions[tombstone.ID]; ok || tombstone.ID == "" {
+			continue
+		}
+		if err := chat.SaveTombstone(tombstone); err != nil {
+			return "", err
+		}
+		deletions += 1
+	}
+
+	history := make([]*Message, 0, len(chat.History)+len(archive.Messages))
+	known := map[string]bool{}
+	for _, message := range chat.History {
+		if _, deleted := chat.Deletions[message.ID]; !deleted {
+			history = append(history, message)
+			known[message.ID] = true
+		}
+	}
+	messages := 0
+	for _, message := range imported {
+		if _, deleted := chat.Deletions[message.ID]; deleted || message.ID == "" || known[message.ID] {
+			continue
+		}
+		history = append(history, message)
+		known[message.ID] = true
+		messages += 1
+	}
+	sort.SliceStable(history, func(i, j int) bool { return history[i].CreatedAt.Before(history[j].CreatedAt) })
+	if err := chat.ReplaceHistory(history); err != nil {
+		return "", err
+	}
+	summary := fmt.Sprintf("imported %d users, %d messages and %d deletions", users, messages, deletions)
+	if archive.Room != nil && archive.Room.Name != chat.Room.Name {
+		summary += fmt.Sprintf(" from room \"%s\" into \"%s\"", archive.Room.Name, chat.Room.Name)
+	}
+	return summary, nil
+}
+
+// ImportedRole keeps moderators of an archive and demotes everyone else to member,
+// owners are only granted on the server itself.
+func ImportedRole(role string) string {
+	if role == RoleOwner || role == RoleModerator {
+		return RoleModerator
+	}
+	return RoleMember
+}
+
+// ReplaceHistory rewrites the redis history and the search index. Callers must hold the chat lock.
+func (chat *Chat) ReplaceHistory(history []*Message) error {
+	if err := writeHistory(context.Background(), chat.RedisClient, history); err != nil {
+		return fmt.Errorf("failed to replace redis history: %s", err)
+	}
+	chat.History = history
+	chat.Index = NewSearchIndex(history)
+	return nil
+}
+
+// WriteArchive writes an archive as JSON Lines or as a plaintext or Markdown transcript.
+func WriteArchive(w io.Writer, archive *Archive, format string) error {
+	switch format {
+	case JSONLFormat, "":
+		return WriteArchiveJSONL(w, archive)
+	case TextFormat, MarkdownFormat:
+		return WriteTranscript(w, archive, format == MarkdownFormat)
+	default:
+		return fmt.Errorf("unknown archive format \"%s\"", format)
+	}
+}
+
+func WriteArchiveJSONL(w io.Writer, archive *Archive) error {
+	encoder := json.NewEncoder(w)
+	records := make([]*ArchiveRecord, 0)
+	if archive.Room != nil {
+		records = append(records, &ArchiveRecord{Type: RoomRecord, Room: archive.Room})
+	}
+	for _, user := range archive.Users {
+		records = append(records, &ArchiveRecord{Type: UserRecord, User: user})
+	}
+	for _, message := range archive.Messages {
+		records = append(records, &ArchiveRecord{Type: MessageRecord, Message: message})
+	}
+	for _, tombstone := range archive.Deletions {
+		records = append(records, &ArchiveRecord{Type: DeletionRecord, Deletion: tombstone})
+	}
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return fmt.Errorf("could not write archive record: %s", err)
+		}
+	}
+	return nil
+}
+
+func ReadArchiveJSONL(r io.Reader) (*Archive, error) {
+	archive := &Archive{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, utils.MaxPacketSize), 16*utils.MaxPacketSize)
+	line := 0
+	for scanner.Scan() {
+		line += 1
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var record ArchiveRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("invalid archive record on line %d: %s", line, err)
+		}
+		switch {
+		case record.Type == RoomRecord && record.Room != nil:
+			archive.Room = record.Room
+		case record.Type == UserRecord && record.User != nil:
+			archive.Users = append(archive.Users, record.User)
+		case record.Type == MessageRecord && record.Message != nil:
+			archive.Messages = append(archive.Messages, record.Message)
+		case record.Type == DeletionRecord && record.Deletion != nil:
+			archive.Deletions = append(archive.Deletions, record.Deletion)
+		default:
+			return nil, fmt.Errorf("invalid archive record on line %d: unknown type \"%s\"", line, record.Type)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read archive: %s", err)
+	}
+	return archive, nil
+}
+
+// WriteTranscript writes the messages of an archive in a human readable form.
+func WriteTranscript(w io.Writer, archive *Archive, markdown bool) error {
+	names := map[string]string{}
+	for _, user := range archive.Users {
+		names[user.ID] = user.Name
+	}
+	room := DefaultRoomName
+	if archive.Room != nil {
+		room = archive.Room.Name
+	}
+	var b strings.Builder
+	if markdown {
+		fmt.Fprintf(&b, "# #%s\n\n", EscapeMarkdown(room))
+	} else {
+		fmt.Fprintf(&b, "#%s\n\n", room)
+	}
+	for _, message := range archive.Messages {
+		author := message.AuthorName
+		if name, ok := names[message.AuthorID]; ok {
+			author = name
+		}
+		if author == "" {
+			author = DefaultNickname
+		}
+		date := message.CreatedAt.Format(transcriptDate)
+		edited := ""
+		if message.Edited {
+			edited = " (edited)"
+		}
+		if markdown {
+			fmt.Fprintf(&b, "**%s** _%s_%s\n\n", EscapeMarkdown(author), date, edited)
+			for _, line := range strings.Split(message.Content, "\n") {
+				fmt.Fprintf(&b, "> %s\n", EscapeMarkdown(line))
+			}
+			b.WriteString("\n")
+			continue
+		}
+		fmt.Fprintf(&b, "[%s] %s: %s%s\n", date, author, message.Content, edited)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var markdownEscaper = strings.NewReplacer(
+	"\\", "\\\\", "`", "\\`", "*", "\\*", "_", "\\_", "{", "\\{", "}", "\\}", "[", "\\[", "]", "\\]",
+	"(", "\\(", ")", "\\)", "#", "\\#", "+", "\\+", "-", "\\-", ".", "\\.", "!", "\\!", "|", "\\|",
+	"&", "&amp;", "<", "&lt;", ">", "&gt;", "~", "\\~",
+)
+
+// EscapeMarkdown escapes text so a transcript renders it as written.
+func EscapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bytes"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchive_JSONL(t *testing.T) {
+	createdAt := time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)
+	archive := &Archive{
+		Room:      NewRoom("general"),
+		Users:     []*Client{{ID: "a1", Name: "alice", Role: RoleOwner}},
+		Messages:  []*Message{{ID: "m1", Content: "hello\nworld", AuthorID: "a1", CreatedAt: createdAt, Edited: true}},
+		Deletions: []*Tombstone{{ID: "m0", DeletedAt: createdAt, DeletedBy: "a1"}},
+	}
+
+	t.Run("Archives survive a round trip", func(t *testing.T) {
+		var b bytes.Buffer
+		assert.NoError(t, WriteArchive(&b, archive, JSONLFormat))
+		read, err := ReadArchiveJSONL(&b)
+		assert.NoError(t, err)
+		assert.Equal(t, archive, read)
+	})
+
+	t.Run("Unknown records are rejected", func(t *testing.T) {
+		_, err := ReadArchiveJSONL(bytes.NewBufferString(`{"type":"room"}` + "\n"))
+		assert.Error(t, err)
+	})
+
+	t.Run("Transcripts resolve author names", func(t *testing.T) {
+		var b bytes.Buffer
+		assert.NoError(t, WriteArchive(&b, archive, MarkdownFormat))
+		assert.Contains(t, b.String(), "**alice** _2024-03-01 10:30:00_ (edited)\n\n> hello\n> world\n")
+		b.Reset()
+		assert.NoError(t, WriteArchive(&b, archive, TextFormat))
+		assert.Contains(t, b.String(), "[2024-03-01 10:30:00] alice: hello\nworld (edited)\n")
+	})
+
+	t.Run("Markdown transcripts escape content", func(t *testing.T) {
+		archive := &Archive{Messages: []*Message{{Content: "# *not* a [link](x) <b>", AuthorName: "bob_1"}}}
+		var b bytes.Buffer
+		assert.NoError(t, WriteArchive(&b, archive, MarkdownFormat))
+		assert.Contains(t, b.String(), "**bob\\_1**")
+		assert.Contains(t, b.String(), "> \\# \\*not\\* a \\[link\\]\\(x\\) &lt;b&gt;\n")
+	})
+}
+
+func TestChat_ExportImport(t *testing.T) {
+	source := StartTestServer(t)
+	conn := CreateTestConnection(t, source.Addr().String())
+	defer conn.Close()
+	alice := AddTestClient(t, conn, &LoginInput{Username: "alice"})
+	kept := SendTestMessage(t, conn, &Message{Content: "kept", AuthorID: alice.AssignedId})
+	deleted := SendTestMessage(t, conn, &Message{Content: "deleted", AuthorID: alice.AssignedId})
+	if err := utils.WriteToUDPConn(conn, utils.DeleteMessageCommand, deleted); err != nil {
+		t.Fatal("could not write to UDP connection: ", err)
+	}
+	ReadTestCommand(t, conn, utils.DeleteMessageCommand)
+
+	archive := source.Chat.Export()
+	assert.Len(t, archive.Messages, 1)
+	assert.Len(t, archive.Deletions, 1)
+	if assert.Len(t, archive.Users, 1) {
+		assert.Nil(t, archive.Users[0].Address)
+	}
+
+	target := StartTestServer(t)
+	t.Run("Importing preserves ids, timestamps and deletions", func(t *testing.T) {
+		summary, err := target.Chat.Import(archive)
+		assert.NoError(t, err)
+		assert.Equal(t, "imported 1 users, 1 messages and 1 deletions", summary)
+		history := FetchHistoryFromRedis(target.RedisClient, target.Chat.Logger)
+		assert.Len(t, history, 1)
+		assert.Equal(t, kept.ID, history[0].ID)
+		assert.Equal(t, alice.AssignedId, history[0].AuthorID)
+		assert.True(t, kept.CreatedAt.Equal(history[0].CreatedAt))
+		assert.Contains(t, FetchDeletionsFromRedis(target.RedisClient, target.Chat.Logger), deleted.ID)
+		unlock := target.Chat.rlock()
+		assert.Equal(t, "alice", target.Chat.Clients[alice.AssignedId].Name)
+		assert.False(t, target.Chat.Clients[alice.AssignedId].Online)
+		unlock()
+	})
+
+	t.Run("Importing twice doesn't duplicate records", func(t *testing.T) {
+		summary, err := target.Chat.Import(archive)
+		assert.NoError(t, err)
+		assert.Equal(t, "imported 0 users, 0 messages and 0 deletions", summary)
+		assert.Len(t, target.Chat.History, 1)
+	})
+	t.Run("Imported owners are demoted", func(t *testing.T) {
+		target := StartTestServer(t)
+		_, err := target.Chat.Import(&Archive{Users: []*Client{{ID: "o1", Name: "olivia", Role: RoleOwner}, {ID: "x1", Name: "xavier", Role: "admin"}}})
+		assert.NoError(t, err)
+		unlock := target.Chat.rlock()
+		assert.Equal(t, RoleModerator, target.Chat.Clients["o1"].Role)
+		assert.Equal(t, RoleMember, target.Chat.Clients["x1"].Role)
+		unlock()
+	})
+
+	t.Run("Archives with invalid content are rejected", func(t *testing.T) {
+		target := StartTestServer(t)
+		for _, content := range []string{"", "bad \u202e", strings.Repeat("a", MaxMessageLength+1)} {
+			_, err := target.Chat.Import(&Archive{
+				Users:    []*Client{{ID: "b1", Name: "bob"}},
+				Messages: []*Message{{ID: "m1", Content: content, AuthorID: "b1"}},
+			})
+			assert.Error(t, err)
+		}
+		unlock := target.Chat.rlock()
+		assert.Empty(t, target.Chat.Clients)
+		assert.Empty(t, target.Chat.History)
+		unlock()
+	})
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+)
+
+// StartBenchmarkChat runs a quiet server with n online clients whose packets are sent to a socket discarding them.
+func StartBenchmarkChat(b *testing.B, n int) *Chat {
+	s := NewTestServer(b)
+	s.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	RunTestServer(b, s)
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal("could not create sink: ", err)
+	}
+	b.Cleanup(func() { sink.Close() })
+	go func() {
+		for {
+			if _, _, err := utils.ReadUDPConn(sink); err != nil {
+				return
+			}
+		}
+	}()
+	chat := s.Chat
+	chat.mu.Lock()
+	defer chat.mu.Unlock()
+	for i := 0; i < n; i++ {
+		client := NewClient(chat, sink.LocalAddr().(*net.UDPAddr), fmt.Sprintf("bench-%d", i))
+		chat.Clients[client.ID] = client
+		chat.connected += 1
+		chat.StartClient(client)
+	}
+	return chat
+}
+
+func BenchmarkChat_ListenToChannels(b *testing.B) {
+	for _, n := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("%d clients", n), func(b *testing.B) {
+			chat := StartBenchmarkChat(b, n)
+			message := Message{ID: "bench", Content: "hello everyone", AuthorName: "bench"}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				chat.BroadcastMessage(message)
+			}
+			chat.BroadcastMessage(message) // returns once the previous fan-out is done
+		})
+	}
+}
+
+func BenchmarkChat_SaveMessageToRedis(b *testing.B) {
+	chat := StartBenchmarkChat(b, 0)
+	message := &Message{ID: "bench", Content: "hello everyone", AuthorID: "bench"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := chat.SaveMessageToRedis(message); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
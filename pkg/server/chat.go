@@ -7,54 +7,193 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/hirotachi/udp-cli-chat/pkg/utils"
 	"github.com/rs/xid"
-	"log"
+	"log/slog"
 	"net"
+	"strings"
+	"sync"
 	"time"
 )
 
 type Chat struct {
+	mu            sync.RWMutex
 	RedisClient   *redis.Client
 	conn          *net.UDPConn
 	History       []*Message
 	Clients       map[string]*Client
 	BroadcastChan chan []byte
 	MessageChan   chan Message
+	Bans          map[string]*Ban
+	Room          *Room
+	Index         *SearchIndex
+	ReadCursors   map[string]string // client id to last read message id
+	Deletions     map[string]*Tombstone
+	Epoch         time.Time           // messages were last removed without tombstones, older caches are resent in full
+	syncs         map[string][]string // client id to message ids of the initial history last sent, by order
+	Metrics       *Metrics
+	Logger        *slog.Logger   // tagged with the room name
+	Capture       *utils.Capture // records every packet when set
 	connected     int
 	HistoryLimit  int
+	startedAt     time.Time
+	activity      activity // packets being handled, replays wait for it to settle
+	kicked        []*kick  // kicked under the chat lock, see AnnounceKicks
 }
 
 type InitialPayload struct {
-	AssignedId    string `json:"assigned_id,omitempty"`
-	HistoryLength int    `json:"history_length,omitempty"`
+	AssignedId    string         `json:"assigned_id,omitempty"`
+	Username      string         `json:"username,omitempty"`
+	HistoryLength int            `json:"history_length,omitempty"`
+	UnreadCount   int            `json:"unread_count,omitempty"` // unread messages at the end of history
+	Role          string         `json:"role,omitempty"`
+	Roster        []*RosterEntry `json:"roster,omitempty"`
+	Room          string         `json:"room,omitempty"`
+	Delta         bool           `json:"delta,omitempty"`   // history only holds changes since the client cache
+	Deleted       []string       `json:"deleted,omitempty"` // ids of cached messages deleted since, delta only
+	SyncedAt      time.Time      `json:"synced_at"`         // server time the history was taken at
+}
+
+// lock takes the chat lock and returns its release, which does nothing once called, so handlers
+// can defer it to release the lock on panics while still releasing it early before broadcasting.
+func (chat *Chat) lock() func() {
+	chat.mu.Lock()
+	var once sync.Once
+	return func() { once.Do(chat.mu.Unlock) }
+}
+
+// rlock is lock for reading.
+func (chat *Chat) rlock() func() {
+	chat.mu.RLock()
+	var once sync.Once
+	return func() { once.Do(chat.mu.RUnlock) }
 }
 
 func NewChat(server *Server) *Chat {
-	clientsMap, connected := FetchClientsFromRedis(server.RedisClient)
-	history := FetchHistoryFromRedis(server.RedisClient)
-	return &Chat{
+	room := server.Room
+	if room == nil {
+		room = NewRoom(DefaultRoomName)
+	}
+	logger := server.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With("room", room.Name)
+	clientsMap, connected := FetchClientsFromRedis(server.RedisClient, logger)
+	history := FetchHistoryFromRedis(server.RedisClient, logger)
+	chat := &Chat{
 		RedisClient:   server.RedisClient,
 		conn:          server.conn,
 		History:       history,
 		Clients:       clientsMap,
 		BroadcastChan: make(chan []byte),
 		MessageChan:   make(chan Message),
+		Bans:          FetchBansFromRedis(server.RedisClient, logger),
+		ReadCursors:   FetchReadCursorsFromRedis(server.RedisClient, logger),
+		Deletions:     FetchDeletionsFromRedis(server.RedisClient, logger),
+		Epoch:         FetchEpochFromRedis(server.RedisClient, logger),
+		syncs:         map[string][]string{},
+		Room:          room,
+		Index:         NewSearchIndex(history),
+		Metrics:       NewMetrics(),
+		Logger:        logger,
+		Capture:       server.Capture,
 		connected:     connected,
 		HistoryLimit:  20,
+		startedAt:     time.Now(),
+	}
+	chat.RedisClient.AddHook(storageHook{histogram: chat.Metrics.storage})
+	chat.ResetSessions()
+	return chat
+}
+
+// ResetSessions marks clients left online by a previous server run as offline
+// since their sessions didn't survive the restart.
+func (chat *Chat) ResetSessions() {
+	for _, client := range chat.Clients {
+		if !client.Online {
+			continue
+		}
+		if err := chat.UpdateClient(client, func(c *Client) { c.Online = false }); err != nil {
+			chat.Logger.Error("failed to reset session", "client_id", client.ID, "error", err)
+			continue
+		}
+		chat.connected -= 1
 	}
 }
 
-func FetchHistoryFromRedis(redisClient *redis.Client) []*Message {
+func FetchHistoryFromRedis(redisClient *redis.Client, logger *slog.Logger) []*Message {
+	ctx := context.Background()
 	history := make([]*Message, 0)
-	if err := redisClient.LRange(context.Background(), utils.RedisHistoryKey, 0, -1).ScanSlice(&history); err != nil && err != redis.Nil {
-		log.Println("could not fetch redis messages history: ", err)
+	ids, err := redisClient.LRange(ctx, utils.RedisHistoryKey, 0, -1).Result()
+	if err != nil && err != redis.Nil {
+		logger.Error("could not fetch redis messages history", "error", err)
+		return history
+	}
+	if len(ids) == 0 {
+		return history
+	}
+	if strings.HasPrefix(ids[0], "{") { // history list used to hold the messages themselves
+		return migrateHistory(redisClient, logger, ids)
+	}
+	entries, err := redisClient.HMGet(ctx, utils.RedisMessagesKey, ids...).Result()
+	if err != nil {
+		logger.Error("could not fetch redis messages", "error", err)
+		return history
+	}
+	for i, entry := range entries {
+		str, ok := entry.(string)
+		if !ok {
+			logger.Error("history references a missing message", "message_id", ids[i])
+			continue
+		}
+		var message Message
+		if err := json.Unmarshal([]byte(str), &message); err != nil {
+			logger.Error("could not unmarshal message", "message_id", ids[i], "error", err)
+			continue
+		}
+		history = append(history, &message)
+	}
+	return history
+}
+
+// migrateHistory moves messages stored in the history list into the messages hash.
+func migrateHistory(redisClient *redis.Client, logger *slog.Logger, entries []string) []*Message {
+	history := make([]*Message, 0, len(entries))
+	for _, entry := range entries {
+		var message Message
+		if err := json.Unmarshal([]byte(entry), &message); err != nil {
+			logger.Error("could not unmarshal message", "error", err)
+			continue
+		}
+		history = append(history, &message)
+	}
+	if err := writeHistory(context.Background(), redisClient, history); err != nil {
+		logger.Error("could not migrate redis history", "error", err)
+		return history
 	}
+	logger.Info("migrated redis history", "messages", len(history))
 	return history
 }
 
-func FetchClientsFromRedis(redisClient *redis.Client) (map[string]*Client, int) {
+// writeHistory replaces the stored history with history.
+func writeHistory(ctx context.Context, redisClient *redis.Client, history []*Message) error {
+	pipe := redisClient.TxPipeline()
+	pipe.Del(ctx, utils.RedisHistoryKey, utils.RedisMessagesKey)
+	for _, message := range history {
+		bytes, err := json.Marshal(message)
+		if err != nil {
+			return fmt.Errorf("failed to marshal message: %s", err)
+		}
+		pipe.HSet(ctx, utils.RedisMessagesKey, message.ID, string(bytes))
+		pipe.RPush(ctx, utils.RedisHistoryKey, message.ID)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func FetchClientsFromRedis(redisClient *redis.Client, logger *slog.Logger) (map[string]*Client, int) {
 	clients := make([]*Client, 0)
 	if err := redisClient.SMembers(context.Background(), utils.RedisClientsSetKey).ScanSlice(&clients); err != nil && err != redis.Nil {
-		log.Println("could not fetch redis clients list: ", err)
+		logger.Error("could not fetch redis clients list", "error", err)
 	}
 	clientsByIdMap := map[string]*Client{}
 	connected := 0
@@ -73,6 +212,7 @@ func FetchClientsFromRedis(redisClient *redis.Client) (map[string]*Client, int)
 func (chat *Chat) Listen() {
 	defer chat.conn.Close()
 	go chat.ListenToChannels()
+	go chat.RunCompaction(CompactionInterval)
 	for {
 		chat.HandleUDPConnection()
 	}
@@ -81,236 +221,454 @@ func (chat *Chat) Listen() {
 func (chat *Chat) HandleUDPConnection() {
 	bytes, addr, err := utils.ReadUDPConn(chat.conn)
 	if err != nil {
-		log.Printf("cannot read from %s connection: %s\n", addr, err)
+		chat.Logger.Error("failed to read packet", "error", err)
 		return
 	}
-	command, data := utils.ParseCommandAndData(bytes)
+	chat.Capture.Record(utils.InboundPacket, addr, bytes)
+	chat.activity.received()
 	go func() {
-		switch command {
-		case utils.ConnectCommand:
-			chat.Join(addr, data)
-		case utils.AddMessageCommand:
-			chat.AddMessage(data, addr)
-		case utils.DeleteMessageCommand:
-			chat.DeleteMessage(data, addr)
-		case utils.DisconnectCommand:
-			chat.Disconnect(data, addr)
-		default:
-			log.Printf("unknown command \"%s\" from address: %s\n", command, addr)
-		}
+		defer chat.activity.done()
+		chat.HandlePacket(bytes, addr)
+		chat.RecordState()
 	}()
 }
 
+// HandlePacket dispatches a packet received from addr to the handler of its command.
+func (chat *Chat) HandlePacket(packet []byte, addr *net.UDPAddr) {
+	command, data := utils.ParseCommandAndData(packet)
+	defer chat.RecoverHandler(command, addr)
+	chat.Logger.Debug("packet received", "addr", addr.String(), "command", command, "data", string(data))
+	switch command {
+	case utils.ConnectCommand:
+		chat.Join(addr, data)
+	case utils.AddMessageCommand:
+		chat.AddMessage(data, addr)
+	case utils.DeleteMessageCommand:
+		chat.DeleteMessage(data, addr)
+	case utils.DisconnectCommand:
+		chat.Disconnect(data, addr)
+	case utils.ModerateCommand:
+		chat.Moderate(data, addr)
+	case utils.AwayCommand:
+		chat.SetAway(data, addr)
+	case utils.WhoisCommand:
+		chat.Whois(data, addr)
+	case utils.NickCommand:
+		chat.ChangeNickname(data, addr)
+	case utils.TypingCommand:
+		chat.Typing(data, addr)
+	case utils.AddReactionCommand:
+		chat.React(data, addr, true)
+	case utils.RemoveReactionCommand:
+		chat.React(data, addr, false)
+	case utils.MentionsCommand:
+		chat.SendMentions(data, addr)
+	case utils.MarkReadCommand:
+		chat.MarkRead(data, addr)
+	case utils.HistoryPageCommand:
+		chat.SendHistoryPage(data, addr)
+	case utils.SearchCommand:
+		chat.SendSearchResults(data, addr)
+	case utils.ResendHistoryCommand:
+		chat.ResendHistory(data, addr)
+	default:
+		chat.Logger.Warn("unknown command", "addr", addr.String(), "command", command)
+		chat.SendError(addr, command, UnknownCommandCode, "The server doesnt know this command.")
+		chat.Metrics.DecodeError()
+		command = UnknownCommand
+	}
+	chat.Metrics.PacketIn(command)
+}
+
 func (chat *Chat) Join(addr *net.UDPAddr, data []byte) {
 	ctx := context.Background()
 
 	var client *Client
-	var oldClient Client // to be deleted from redis if client is reconnecting
+	var oldEntry []byte // redis entry to be replaced if client is reconnecting
+	wasOnline := false
+	oldName := ""
+	nameNotice := ""
 
-	username := "guest"
+	username := DefaultNickname
 	var loginInput LoginInput
 	if err := json.Unmarshal(data, &loginInput); err != nil {
-		log.Println("failed to unmarshal login input")
+		chat.Metrics.DecodeError()
+		chat.Logger.Warn("failed to unmarshal login input", "addr", addr.String(), "error", err)
+		chat.SendError(addr, utils.ConnectCommand, InvalidRequestCode, "The request could not be decoded.")
+		return
 	}
 	if loginInput.Username != "" {
 		username = loginInput.Username
 	}
 
+	unlock := chat.lock()
+	defer unlock()
+	ip := addr.IP
+	if _, known := chat.Clients[loginInput.AssignedId]; known && loginInput.AssignedId != "" {
+		ip = nil // address bans keep out new identities only, others behind the same address can still rejoin
+	}
+	if ban := chat.FindActiveBan(loginInput.AssignedId, ip); ban != nil {
+		unlock()
+		chat.Logger.Info("banned client tried to connect", "addr", addr.String(), "client_id", ban.ClientID)
+		chat.SendToAddress(addr, utils.KickedCommand, ban.Notice())
+		return
+	}
+
 	if loginInput.AssignedId != "" {
 		c, ok := chat.Clients[loginInput.AssignedId]
 		if ok {
+			bytes, err := json.Marshal(c)
+			if err != nil {
+				unlock()
+				chat.Logger.Error("failed to marshal reconnecting client", "addr", addr.String(), "client_id", c.ID, "error", err)
+				chat.SendError(addr, utils.ConnectCommand, InternalErrorCode, "The server failed to handle the request, try again.")
+				return
+			}
 			client = c
-			oldClient = *c
-		}
-		if client.Name != loginInput.Username && loginInput.Username != "" { // in case user decided to change when reconnecting
-			client.Name = loginInput.Username
+			oldEntry = bytes
+			wasOnline = c.Online
+			if client.Name != loginInput.Username && loginInput.Username != "" { // in case user decided to change when reconnecting
+				if err := chat.CheckNickname(loginInput.Username, client.ID); err != nil {
+					nameNotice = fmt.Sprintf("Could not change nickname: %s.", err)
+				} else {
+					oldName = client.Name
+					client.Name = loginInput.Username
+				}
+			}
+			client.SetAddress(addr)
+			client.Online = true
+			client.Away = false
+			client.AwayMessage = ""
+			client.LastSeen = time.Now()
 		}
-		client.Address = addr
-		client.Online = true
 	}
 
 	if client == nil {
-		client = NewClient(chat, addr, username)
+		name := chat.UniqueNickname(username)
+		if name != username {
+			nameNotice = fmt.Sprintf("Nickname \"%s\" is unavailable, you joined as \"%s\".", username, name)
+		}
+		client = NewClient(chat, addr, name)
+		if len(chat.Clients) == 0 { // first registered client owns the chat
+			client.Role = RoleOwner
+		}
 	}
 
-	if (oldClient != Client{}) {
-		//	todo remove old client from redis
-		bytes, err := json.Marshal(oldClient)
-		if err != nil {
-			log.Println("could not marshal old client to be saved to redis: ", err)
-			return
-		}
-		if err := chat.RedisClient.SRem(ctx, utils.RedisClientsSetKey, string(bytes)).Err(); err != nil {
-			log.Println("failed to remove old client from redis set: ", err)
+	if oldEntry != nil {
+		if err := chat.RedisClient.SRem(ctx, utils.RedisClientsSetKey, string(oldEntry)).Err(); err != nil {
+			unlock()
+			chat.Logger.Error("failed to remove reconnecting client from redis", "addr", addr.String(), "client_id", client.ID, "error", err)
+			chat.SendError(addr, utils.ConnectCommand, InternalErrorCode, "The server failed to handle the request, try again.")
 			return
 		}
 	}
 	if err := chat.SaveClientToRedis(client); err != nil {
-		log.Println(err)
+		unlock()
+		chat.Logger.Error("failed to save client", "addr", addr.String(), "client_id", client.ID, "error", err)
+		chat.SendError(addr, utils.ConnectCommand, InternalErrorCode, "The server failed to handle the request, try again.")
 		return
 	}
 	chat.Clients[client.ID] = client
-	if !oldClient.Online {
+	client.Touch()
+	if !wasOnline {
 		chat.connected += 1
 	}
+	chat.StartClient(client)
+	unlock()
 
-	go client.Listen()
-	log.Printf("client \"%s\" connected\n", addr)
+	chat.Logger.Info("client connected", "addr", addr.String(), "client_id", client.ID)
 
-	go chat.SendInitialPayload(client)
+	// presence follows the history so the client always receives its packets in the same order
+	chat.activity.start()
+	go func() {
+		defer chat.activity.done()
+		defer chat.RecoverHandler(utils.ConnectCommand, addr)
+		chat.SendInitialPayload(client, &loginInput)
+		if nameNotice != "" {
+			chat.SendNotice(client, nameNotice)
+		}
+		if oldName != "" {
+			chat.BroadcastPresence(RenamePresence, client, oldName)
+		}
+		if !wasOnline {
+			chat.BroadcastPresence(JoinPresence, client, "")
+		}
+	}()
 }
 
 func (chat *Chat) Disconnect(data []byte, addr *net.UDPAddr) {
 	clientID := string(data)
+	unlock := chat.lock()
+	defer unlock()
 	client, ok := chat.Clients[clientID]
 	if !ok {
-		log.Printf("client \"%s\" does exist\n", clientID)
-		return
-	}
-	clientBytes, err := json.Marshal(client)
-	if err != nil {
-		log.Printf("could not marshal client \"%s\" for redis removal: %s\n", clientID, err)
+		unlock()
+		chat.Logger.Warn("unrecognized client", "addr", addr.String(), "client_id", clientID)
+		chat.SendError(addr, utils.DisconnectCommand, UnknownClientCode, "You are not connected, reconnect to continue.")
 		return
 	}
-
-	// remove client from redis to re-add with updated status
-	if err := chat.RedisClient.SRem(context.Background(), utils.RedisClientsSetKey, string(clientBytes)).Err(); err != nil {
-		log.Printf("could not remove client  \"%s\" from redis set: %s\n", clientID, err)
+	if !client.Online {
+		unlock()
+		chat.Logger.Warn("offline client tried to disconnect", "addr", addr.String(), "client_id", clientID)
+		chat.SendError(addr, utils.DisconnectCommand, UnknownClientCode, "You are already disconnected.")
 		return
 	}
-	client.Online = false
-	if err := chat.SaveClientToRedis(client); err != nil {
-		log.Println(err)
+	if err := chat.UpdateClient(client, func(c *Client) {
+		c.Online = false
+		c.LastSeen = time.Now()
+	}); err != nil {
+		unlock()
+		chat.Logger.Error("failed to disconnect client", "addr", addr.String(), "client_id", client.ID, "error", err)
+		chat.SendError(addr, utils.DisconnectCommand, InternalErrorCode, "The server failed to handle the request, try again.")
 		return
 	}
 	chat.connected -= 1
-	if chat.connected == 0 { // clear messages history
-		if err := chat.RedisClient.Del(context.Background(), utils.RedisHistoryKey).Err(); err != nil {
-			log.Println("failed to empty redis history: ", err)
-			return
-		}
-		chat.History = make([]*Message, 0)
-	}
-	log.Printf("client \"%s\" disconnected\n", addr)
+	delete(chat.syncs, client.ID)
+	chat.WipeEphemeral()
+	unlock()
+
+	chat.BroadcastPresence(LeavePresence, client, "")
+	chat.Logger.Info("client disconnected", "addr", addr.String(), "client_id", client.ID)
 }
 
 func (chat *Chat) ListenToChannels() {
 	// iterate over all clients
 	forEachClient := func(isOnline bool, handler func(client *Client)) {
+		chat.mu.RLock()
+		clients := make([]*Client, 0, len(chat.Clients))
 		for _, client := range chat.Clients {
-			c := client
-			if isOnline == c.Online {
-				handler(c)
+			if isOnline == client.Online {
+				clients = append(clients, client)
 			}
 		}
+		chat.mu.RUnlock()
+		for _, client := range clients {
+			handler(client)
+		}
 	}
 	for {
 		select {
 		case msg := <-chat.BroadcastChan:
 			forEachClient(true, func(client *Client) {
-				client.BroadcastChan <- msg
+				chat.Metrics.Queue(1)
+				client.push(msg)
+				chat.Metrics.Queue(-1)
 			})
+			chat.activity.done()
 		case msg := <-chat.MessageChan:
 			forEachClient(true, func(client *Client) {
 				message := msg
 				if client.ID != message.AuthorID { // hide other clients ids from client
 					message.AuthorID = ""
 				}
-				client.MessageChan <- &message
+				message.MentionIDs = msg.mentionsFor(client.ID)
+				chat.Metrics.Queue(1)
+				client.pushMessage(&message)
+				chat.Metrics.Queue(-1)
 			})
+			chat.activity.done()
 		}
 	}
 }
 
-func (chat *Chat) SendInitialPayload(client *Client) {
+// Broadcast sends a packet to all online clients, every client receives broadcasts in the same order.
+func (chat *Chat) Broadcast(command string, data interface{}) {
+	msg := utils.BuildUDPMessage(command, data)
+	if msg == nil {
+		return
+	}
+	chat.activity.start() // done once ListenToChannels handed it to every client
+	chat.BroadcastChan <- msg
+}
+
+// BroadcastMessage sends a new message to all online clients with the ids each of them may see.
+func (chat *Chat) BroadcastMessage(message Message) {
+	chat.activity.start()
+	chat.MessageChan <- message
+}
+
+// SendInitialPayload sends recent history, or only the changes since the client cache described by since.
+func (chat *Chat) SendInitialPayload(client *Client, since *LoginInput) {
 	// send info to client to receive history logs split packets
+	unlock := chat.lock()
+	defer unlock()
+	// recent history is extended back to every message sent since client last read
+	unreadIndex := chat.UnreadIndex(client.ID)
+	unreadCount := len(chat.History) - unreadIndex
+	start := len(chat.History) - chat.HistoryLimit
+	if unreadIndex < start {
+		start = unreadIndex
+	}
+	if start < len(chat.History)-UnreadLimit {
+		start = len(chat.History) - UnreadLimit
+	}
+	if start < 0 {
+		start = 0
+	}
+	history, deleted, delta := chat.DeltaHistory(since)
+	if !delta {
+		history = make([]*Message, len(chat.History)-start)
+		copy(history, chat.History[start:])
+	}
+	synced := make([]string, len(history))
+	for i, message := range history {
+		synced[i] = message.ID
+	}
+	chat.syncs[client.ID] = synced                                      // remembered so lost logs can be resent by order
+	if _, ok := chat.ReadCursors[client.ID]; !ok && len(history) != 0 { // new clients start reading from now on
+		if err := chat.SaveReadCursor(client.ID, history[len(history)-1].ID); err != nil {
+			chat.Logger.Error("failed to save read cursor", "client_id", client.ID, "error", err)
+		}
+	}
 	initialPayload := &InitialPayload{
 		AssignedId:    client.ID,
-		HistoryLength: len(chat.History),
-	}
-	utils.BroadcastWithCommand(client.BroadcastChan, utils.InitialPayloadCommand, initialPayload)
+		Username:      client.Name,
+		HistoryLength: len(history),
+		UnreadCount:   unreadCount,
+		Role:          client.Role,
+		Roster:        chat.Roster(),
+		Room:          chat.Room.Name,
+		Delta:         delta,
+		Deleted:       deleted,
+		SyncedAt:      time.Now(),
+	}
+	names := chat.ClientNames()
+	unlock()
+	client.Send(utils.InitialPayloadCommand, initialPayload)
 
-	// send each history log by itself to avoid data loss
-	for i, message := range chat.History {
-		m := *message // copy to avoid mutating message in history
-		authorName := "guest"
-		author, ok := chat.Clients[m.AuthorID]
-		if ok {
-			authorName = author.Name
-		}
-		m.AuthorName = authorName // author name to message to be identified by other clients
-		if m.AuthorID != client.ID {
-			m.AuthorID = ""
+	// send each history log by itself to avoid data loss, paged so clients are not flooded
+	for i, message := range history {
+		if i != 0 && i%HistoryPageSize == 0 {
+			time.Sleep(HistoryPageWait)
 		}
 		historyLog := &HistoryLog{
 			Order:   i,
-			Message: &m,
+			Message: message.ForClient(client.ID, names),
 		}
-		utils.BroadcastWithCommand(client.BroadcastChan, utils.AddHistoryCommand, historyLog)
+		client.Send(utils.AddHistoryCommand, historyLog)
 	}
 }
 
 func (chat *Chat) AddMessage(data []byte, addr *net.UDPAddr) {
 	var message Message
 	if err := json.Unmarshal(data, &message); err != nil {
-		log.Println("failed to unmarshal message: ", err)
+		chat.Metrics.DecodeError()
+		chat.Logger.Warn("failed to unmarshal message", "addr", addr.String(), "error", err)
+		chat.SendError(addr, utils.AddMessageCommand, InvalidRequestCode, "The request could not be decoded.")
 		return
 	}
-	var client *Client
-	if message.AuthorID != "" { // check if client exists before saving message
-		var ok bool
-		client, ok = chat.Clients[message.AuthorID]
-		if !ok {
-			log.Printf("Unrecognized client \"%s\" with id \"%s\"\n", addr, message.AuthorID)
-			return
-		}
+	if message.AuthorID == "" {
+		chat.Logger.Warn("message is missing the client id", "addr", addr.String())
+		chat.SendError(addr, utils.AddMessageCommand, InvalidRequestCode, "The message is missing its author.")
+		return
+	}
+	content, err := NormalizeContent(message.Content)
+	if err != nil {
+		chat.Logger.Warn("invalid message content", "addr", addr.String(), "client_id", message.AuthorID, "error", err)
+		chat.SendError(addr, utils.AddMessageCommand, InvalidContentCode, fmt.Sprintf("Could not send the message: %s.", err))
+		return
+	}
+	message.Content = content
+	unlock := chat.lock()
+	defer unlock()
+	client, ok := chat.Clients[message.AuthorID] // check if client exists before saving message
+	if !ok {
+		unlock()
+		chat.Logger.Warn("unrecognized client", "addr", addr.String(), "client_id", message.AuthorID)
+		chat.SendError(addr, utils.AddMessageCommand, UnknownClientCode, "You are not connected, reconnect to continue.")
+		return
+	}
+	if !client.Online {
+		unlock()
+		chat.Logger.Warn("offline client tried to send a message", "addr", addr.String(), "client_id", message.AuthorID)
+		chat.SendError(addr, utils.AddMessageCommand, UnknownClientCode, "You are not connected, reconnect to continue.")
+		return
+	}
+	client.Touch()
+	if client.IsMuted(time.Now()) {
+		unlock()
+		chat.SendError(addr, utils.AddMessageCommand, ForbiddenCode, "You are muted and cannot send messages.")
+		return
+	}
+	if message.ReplyTo != "" && chat.FindMessage(message.ReplyTo) == nil {
+		unlock()
+		chat.SendError(addr, utils.AddMessageCommand, NotFoundCode, "The message you replied to doesnt exist anymore.")
+		return
 	}
+	// fields below are owned by the server, whatever the client sent is replaced
 	message.ID = xid.New().String()
 	message.CreatedAt = time.Now()
+	message.UpdatedAt = time.Time{}
+	message.Edited = false
+	message.AuthorName = "" // resolved from the author id when sent
+	message.MentionIDs = chat.ParseMentions(message.Content)
+	message.Reactions = nil
 	if err := chat.SaveMessageToRedis(&message); err != nil {
-		log.Println(err)
+		unlock()
+		chat.Logger.Error("failed to save message", "addr", addr.String(), "client_id", client.ID, "error", err)
+		chat.SendError(addr, utils.AddMessageCommand, InternalErrorCode, "The server failed to handle the request, try again.")
 		return
 	}
-	history := chat.History
-	if len(history) == chat.HistoryLimit { // limit history
-		history = history[len(history)-19:]
-	}
 	msg := message // copy so message doesn't get mutated
 	chat.History = append(chat.History, &msg)
+	chat.Index.Add(&msg)
 	message.AuthorName = client.Name // add author name to be recognized by other clients
+	unlock()
 
-	chat.MessageChan <- message
+	chat.BroadcastMessage(message)
 }
 
 func (chat *Chat) DeleteMessage(data []byte, addr *net.UDPAddr) {
 	var msg Message
 	if err := json.Unmarshal(data, &msg); err != nil {
-		log.Println("failed to unmarshal deleted message: ", err)
+		chat.Metrics.DecodeError()
+		chat.Logger.Warn("failed to unmarshal deleted message", "addr", addr.String(), "error", err)
+		chat.SendError(addr, utils.DeleteMessageCommand, InvalidRequestCode, "The request could not be decoded.")
 		return
 	}
 	if msg.AuthorID == "" || msg.ID == "" {
-		log.Printf("failed to delete message from \"%s\"\n", addr)
+		chat.Logger.Warn("deletion is missing the message or client id", "addr", addr.String())
+		chat.SendError(addr, utils.DeleteMessageCommand, InvalidRequestCode, "The deletion is missing the message or its requester.")
 		return
 	}
-	_, ok := chat.Clients[msg.AuthorID]
+	unlock := chat.lock()
+	defer unlock()
+	requester, ok := chat.Clients[msg.AuthorID]
 	if !ok {
-		log.Printf("failed to delete message from \"%s\"\n", addr)
+		unlock()
+		chat.Logger.Warn("unrecognized client", "addr", addr.String(), "client_id", msg.AuthorID)
+		chat.SendError(addr, utils.DeleteMessageCommand, UnknownClientCode, "You are not connected, reconnect to continue.")
 		return
 	}
-
-	msg.AuthorName = "" // remove author_name to find on redis list
-	msgBytes, err := json.Marshal(msg)
-	if err != nil {
-		log.Println("failed to marshal msg for redis deletion: ", err)
+	requester.Touch()
+	stored := chat.FindMessage(msg.ID)
+	if stored == nil {
+		unlock()
+		chat.Logger.Warn("message to delete does not exist", "addr", addr.String(), "client_id", requester.ID, "message_id", msg.ID)
+		chat.SendError(addr, utils.DeleteMessageCommand, NotFoundCode, "The message to delete doesnt exist anymore.")
 		return
 	}
-	removedCount, err := chat.RedisClient.LRem(context.Background(), utils.RedisHistoryKey, 1, string(msgBytes)).Result()
-	if err != nil {
-		log.Println("could not remove msg from redis: ", err)
+	if stored.AuthorID != requester.ID && !requester.IsModerator() {
+		unlock()
+		chat.Logger.Warn("client is not allowed to delete message", "addr", addr.String(), "client_id", requester.ID, "message_id", msg.ID)
+		chat.SendError(addr, utils.DeleteMessageCommand, ForbiddenCode, "You are not allowed to delete this message.")
 		return
 	}
-	if removedCount == 0 {
-		log.Printf("message \"%s\" doesnt exists on redis to be removed\n", msg.ID)
+
+	ctx := context.Background()
+	pipe := chat.RedisClient.TxPipeline()
+	removed := pipe.LRem(ctx, utils.RedisHistoryKey, 1, stored.ID)
+	pipe.HDel(ctx, utils.RedisMessagesKey, stored.ID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		unlock()
+		chat.Logger.Error("failed to delete message from redis", "message_id", msg.ID, "error", err)
+		chat.SendError(addr, utils.DeleteMessageCommand, InternalErrorCode, "The server failed to handle the request, try again.")
+		return
+	}
+	if removed.Val() == 0 {
+		unlock()
+		chat.Logger.Warn("message to delete does not exist in redis", "message_id", msg.ID)
+		chat.SendError(addr, utils.DeleteMessageCommand, NotFoundCode, "The message to delete doesnt exist anymore.")
 		return
 	}
 
@@ -322,7 +680,26 @@ func (chat *Chat) DeleteMessage(data []byte, addr *net.UDPAddr) {
 		}
 	}
 	chat.History = newHistory
-	utils.BroadcastWithCommand(chat.BroadcastChan, utils.DeleteMessageCommand, msg.ID)
+	chat.Index.Remove(stored)
+	if err := chat.SaveTombstone(&Tombstone{ID: stored.ID, DeletedAt: time.Now(), DeletedBy: requester.ID}); err != nil {
+		chat.Logger.Error("failed to save tombstone", "message_id", stored.ID, "error", err)
+	}
+	unlock()
+
+	chat.Broadcast(utils.DeleteMessageCommand, msg.ID)
+	if stored.AuthorID != requester.ID {
+		chat.BroadcastNotice(fmt.Sprintf("A message was removed by %s.", requester.Name))
+	}
+}
+
+// FindMessage looks up a message in history by its id.
+func (chat *Chat) FindMessage(id string) *Message {
+	for _, message := range chat.History {
+		if message.ID == id {
+			return message
+		}
+	}
+	return nil
 }
 
 func (chat *Chat) SaveClientToRedis(client *Client) error {
@@ -336,25 +713,65 @@ func (chat *Chat) SaveClientToRedis(client *Client) error {
 	return nil
 }
 
-func (chat *Chat) SaveMessageToRedis(message *Message) error {
+// UpdateClient applies update to client and replaces its record in the redis clients set.
+func (chat *Chat) UpdateClient(client *Client, update func(c *Client)) error {
 	ctx := context.Background()
-	historyLength, err := chat.RedisClient.LLen(ctx, utils.RedisHistoryKey).Result()
+	bytes, err := json.Marshal(client)
 	if err != nil {
-		log.Println("failed to fetch history length from redis: ", err)
+		return fmt.Errorf("could not marshal client \"%s\" for redis removal: %s", client.ID, err)
+	}
+	// remove client from redis to re-add with updated status
+	if err := chat.RedisClient.SRem(ctx, utils.RedisClientsSetKey, string(bytes)).Err(); err != nil {
+		return fmt.Errorf("could not remove client \"%s\" from redis set: %s", client.ID, err)
 	}
+	update(client)
+	return chat.SaveClientToRedis(client)
+}
 
-	if historyLength == int64(chat.HistoryLimit) { // limit history log on redis
-		if err := chat.RedisClient.LTrim(ctx, utils.RedisHistoryKey, 1, -1).Err(); err != nil {
-			return fmt.Errorf("failed to limit redis history to 20 entries: %s", err)
-		}
+// StartClient makes sure client can receive packets, clients fetched from redis have no channels attached.
+func (chat *Chat) StartClient(client *Client) {
+	if client.listening {
+		return
+	}
+	client.chat = chat
+	client.BroadcastChan = make(chan []byte)
+	client.MessageChan = make(chan *Message)
+	client.listening = true
+	go client.Listen()
+}
+
+// SendToAddress sends a packet to an address that might not belong to a registered client.
+func (chat *Chat) SendToAddress(addr *net.UDPAddr, command string, data interface{}) {
+	msg := utils.BuildUDPMessage(command, data)
+	if msg == nil {
+		return
+	}
+	chat.WritePacket(addr, msg)
+}
+
+// WritePacket sends a packet to addr, counting and capturing it.
+func (chat *Chat) WritePacket(addr *net.UDPAddr, msg []byte) {
+	if _, err := chat.conn.WriteToUDP(msg, addr); err != nil {
+		chat.Logger.Error("failed to send packet", "addr", addr.String(), "error", err)
+		return
 	}
+	chat.Metrics.PacketOut(msg)
+	chat.Capture.Record(utils.OutboundPacket, addr, msg)
+	chat.activity.wrote()
+}
 
+func (chat *Chat) SaveMessageToRedis(message *Message) error {
+	ctx := context.Background()
 	bytes, err := json.Marshal(message)
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %s", err)
 	}
-	if err := chat.RedisClient.RPush(ctx, utils.RedisHistoryKey, string(bytes)).Err(); err != nil {
+	pipe := chat.RedisClient.TxPipeline()
+	pipe.HSet(ctx, utils.RedisMessagesKey, message.ID, string(bytes))
+	pipe.RPush(ctx, utils.RedisHistoryKey, message.ID)
+	if _, err := pipe.Exec(ctx); err != nil {
 		return fmt.Errorf("failed to save message to redis history: %s", err)
 	}
+	chat.Metrics.MessageStored()
 	return nil
 }
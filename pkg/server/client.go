@@ -1,10 +1,18 @@
 package server
 
 import (
+	"encoding/json"
 	"github.com/hirotachi/udp-cli-chat/pkg/utils"
 	"github.com/rs/xid"
-	"log"
 	"net"
+	"sync"
+	"time"
+)
+
+const (
+	RoleOwner     = "owner"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
 )
 
 type Client struct {
@@ -12,9 +20,18 @@ type Client struct {
 	Address       *net.UDPAddr  `json:"address"`
 	Online        bool          `json:"online"`
 	ID            string        `json:"id,omitempty"`
-	conn          *net.UDPConn  `json:"-"`
+	Role          string        `json:"role,omitempty"`
+	Muted         bool          `json:"muted,omitempty"`
+	MutedUntil    time.Time     `json:"muted_until,omitempty"` // zero value mutes indefinitely
+	LastSeen      time.Time     `json:"last_seen,omitempty"`   // updated on connection and disconnection
+	Away          bool          `json:"-"`
+	AwayMessage   string        `json:"-"`
 	BroadcastChan chan []byte   `json:"-"`
 	MessageChan   chan *Message `json:"-"`
+	listening     bool
+	mu            sync.Mutex // guards Address which the sender reads while the client reconnects
+	chat          *Chat      // sends packets of the client
+	lastActive    time.Time  // last packet received during the current session, not persisted
 }
 
 func NewClient(chat *Chat, addr *net.UDPAddr, username string) *Client {
@@ -23,12 +40,69 @@ func NewClient(chat *Chat, addr *net.UDPAddr, username string) *Client {
 		Address:       addr,
 		Online:        true,
 		ID:            xid.New().String(),
-		conn:          chat.conn,
+		LastSeen:      time.Now(),
+		Role:          RoleMember,
+		chat:          chat,
 		BroadcastChan: make(chan []byte),
 		MessageChan:   make(chan *Message),
 	}
 }
 
+// Copy returns the stored fields of client without its session, the address included.
+func (c *Client) Copy() *Client {
+	return &Client{
+		Name:       c.Name,
+		Online:     c.Online,
+		ID:         c.ID,
+		Role:       c.Role,
+		Muted:      c.Muted,
+		MutedUntil: c.MutedUntil,
+		LastSeen:   c.LastSeen,
+	}
+}
+
+// Addr returns the address packets of client are sent to.
+func (c *Client) Addr() *net.UDPAddr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Address
+}
+
+// SetAddress moves client to the address it reconnected from.
+func (c *Client) SetAddress(addr *net.UDPAddr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Address = addr
+}
+
+// UnmarshalBinary lets redis scan clients set members into clients.
+func (c *Client) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, c)
+}
+
+// IsModerator reports whether client is allowed to moderate other clients.
+func (c *Client) IsModerator() bool {
+	return c.Role == RoleOwner || c.Role == RoleModerator
+}
+
+// IsMuted reports whether client is muted at the given time.
+func (c *Client) IsMuted(now time.Time) bool {
+	return c.Muted && (c.MutedUntil.IsZero() || now.Before(c.MutedUntil))
+}
+
+// CanModerate reports whether client outranks target.
+func (c *Client) CanModerate(target *Client) bool {
+	if c.ID == target.ID || !c.IsModerator() {
+		return false
+	}
+	return c.Role == RoleOwner || !target.IsModerator()
+}
+
+// Touch records activity of the client during the current session.
+func (c *Client) Touch() {
+	c.lastActive = time.Now()
+}
+
 func (c *Client) Listen() {
 	for {
 		select {
@@ -37,12 +111,30 @@ func (c *Client) Listen() {
 		case msg := <-c.MessageChan:
 			c.SendMessage(utils.BuildUDPMessage(utils.AddMessageCommand, msg))
 		}
+		c.chat.activity.done()
 	}
 }
 
-func (c *Client) SendMessage(msg []byte) {
-	_, err := c.conn.WriteToUDP(msg, c.Address)
-	if err != nil {
-		log.Printf("failed to send message to %s: %s\n", c.Address, err)
+// Send hands a packet to the client sender, packets are written in the order they were handed.
+func (c *Client) Send(command string, data interface{}) {
+	msg := utils.BuildUDPMessage(command, data)
+	if msg == nil {
+		return
 	}
+	c.push(msg)
+}
+
+// push hands msg to the client sender, the chat counts it as in progress until it is written.
+func (c *Client) push(msg []byte) {
+	c.chat.activity.start()
+	c.BroadcastChan <- msg
+}
+
+func (c *Client) pushMessage(message *Message) {
+	c.chat.activity.start()
+	c.MessageChan <- message
+}
+
+func (c *Client) SendMessage(msg []byte) {
+	c.chat.WritePacket(c.Addr(), msg)
 }
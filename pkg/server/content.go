@@ -0,0 +1,45 @@
+package server
+
+import (
+	"fmt"
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MaxMessageLength = 2000 // characters once normalised
+	MaxMessageLines  = 30
+	TabWidth         = 4 // spaces replacing a tab, terminals render tabs inconsistently
+)
+
+// NormalizeContent returns message content composed in NFC with unix line endings and no surrounding blank space,
+// or the reason it can't be sent. Content is stored as written, clients escape it before display.
+func NormalizeContent(content string) (string, error) {
+	if !utf8.ValidString(content) {
+		return "", fmt.Errorf("message is not valid UTF-8")
+	}
+	content = strings.NewReplacer("\r\n", "\n", "\r", "\n", "\t", strings.Repeat(" ", TabWidth)).Replace(content)
+	content = norm.NFC.String(content)
+	for _, r := range content {
+		if r != '\n' && (unicode.IsControl(r) || unicode.Is(unicode.Bidi_Control, r)) {
+			return "", fmt.Errorf("message contains the control character %U", r)
+		}
+	}
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRightFunc(line, unicode.IsSpace)
+	}
+	content = strings.TrimSpace(strings.Join(lines, "\n"))
+	if content == "" {
+		return "", fmt.Errorf("message is empty")
+	}
+	if utf8.RuneCountInString(content) > MaxMessageLength {
+		return "", fmt.Errorf("message is longer than %d characters", MaxMessageLength)
+	}
+	if strings.Count(content, "\n")+1 > MaxMessageLines {
+		return "", fmt.Errorf("message has more than %d lines", MaxMessageLines)
+	}
+	return content, nil
+}
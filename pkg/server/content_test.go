@@ -0,0 +1,45 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeContent(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		content string
+		want    string
+		err     string
+	}{
+		{"Plain text is kept", "hello world", "hello world", ""},
+		{"Markup is stored as written", "[red]hello[::-]", "[red]hello[::-]", ""},
+		{"Surrounding blank space is trimmed", "  \n hello  \n\n", "hello", ""},
+		{"Line endings are unified", "one\r\ntwo\rthree", "one\ntwo\nthree", ""},
+		{"Tabs become spaces", "a\tb", "a    b", ""},
+		{"Content is composed", "cafe\u0301", "caf\u00e9", ""},
+		{"Empty content is rejected", " \n\t ", "", "empty"},
+		{"Invalid UTF-8 is rejected", "hello \xff", "", "UTF-8"},
+		{"Escape sequences are rejected", "\x1b[31mred", "", "U+001B"},
+		{"Bidi overrides are rejected", "abc\u202edef", "", "U+202E"},
+		{"Long content is rejected", strings.Repeat("a", MaxMessageLength+1), "", "longer"},
+		{"Tall content is rejected", strings.Repeat("a\n", MaxMessageLines) + "a", "", "lines"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			content, err := NormalizeContent(test.content)
+			if test.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), test.err)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, content)
+		})
+	}
+	t.Run("Limits count characters, not bytes", func(t *testing.T) {
+		_, err := NormalizeContent(strings.Repeat("é", MaxMessageLength))
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,369 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"time"
+)
+
+const (
+	ListUsersControl    = "users"
+	ListSessionsControl = "sessions"
+	BroadcastControl    = "broadcast"
+	KickControl         = "kick"
+	BanControl          = "ban"
+	UnbanControl        = "unban"
+	PurgeControl        = "purge"
+	StatsControl        = "stats"
+	ExportControl       = "export"
+	ImportControl       = "import"
+
+	maxControlRequestSize = 64 << 20 // imports carry a whole archive in a single request
+)
+
+// DefaultControlPath is where the server exposes its admin control socket by default,
+// in a directory of the current user so other users can't take its place.
+var DefaultControlPath = filepath.Join(os.TempDir(), fmt.Sprintf("udp-chat-%d", os.Getuid()), "udp-chat.sock")
+
+// adminClient issues moderation actions requested through the control socket.
+var adminClient = &Client{ID: "admin", Name: "admin", Role: RoleOwner}
+
+type ControlRequest struct {
+	Command  string   `json:"command"`
+	Target   string   `json:"target,omitempty"`
+	Content  string   `json:"content,omitempty"`
+	Duration string   `json:"duration,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+	Archive  *Archive `json:"archive,omitempty"` // import only
+}
+
+type ControlResponse struct {
+	Error    string         `json:"error,omitempty"`
+	Message  string         `json:"message,omitempty"`
+	Users    []*UserInfo    `json:"users,omitempty"`
+	Sessions []*SessionInfo `json:"sessions,omitempty"`
+	Stats    *Stats         `json:"stats,omitempty"`
+	Archive  *Archive       `json:"archive,omitempty"`
+}
+
+type UserInfo struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Role     string    `json:"role"`
+	Online   bool      `json:"online"`
+	Muted    bool      `json:"muted"`
+	Banned   bool      `json:"banned"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+type SessionInfo struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Address  string    `json:"address"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+type Stats struct {
+	Uptime        string `json:"uptime"`
+	Users         int    `json:"users"`
+	Connected     int    `json:"connected"`
+	HistoryLength int    `json:"history_length"`
+	Bans          int    `json:"bans"`
+	PacketsIn     uint64 `json:"packets_in"`
+	Room          string `json:"room"`
+	Retention     string `json:"retention"`
+}
+
+// ListenControl serves admin requests on a unix socket only accessible by the user running the server.
+func (chat *Chat) ListenControl(path string) (net.Listener, error) {
+	listener, err := listenPrivate(path)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				chat.Logger.Info("control socket closed", "error", err)
+				return
+			}
+			go chat.HandleControlConnection(conn)
+		}
+	}()
+	return listener, nil
+}
+
+// controlListener removes the control socket once closed since it was bound under another name.
+type controlListener struct {
+	net.Listener
+	path string
+}
+
+func (l *controlListener) Close() error {
+	err := l.Listener.Close()
+	if removeErr := os.Remove(l.path); removeErr != nil && !os.IsNotExist(removeErr) && err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// listenPrivate binds a unix socket inside a directory only the current user can enter and moves it
+// to path once its permissions are restricted, so it is never reachable by other users.
+func listenPrivate(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create control socket directory: %s", err)
+	}
+	if err := checkPrivateDir(dir); err != nil {
+		return nil, err
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("refusing to replace %s with the control socket, it is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("could not remove stale control socket: %s", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not check control socket path: %s", err)
+	}
+	private, err := os.MkdirTemp(dir, ".control-")
+	if err != nil {
+		return nil, fmt.Errorf("could not create control socket directory: %s", err)
+	}
+	defer os.RemoveAll(private)
+	bound := filepath.Join(private, "control.sock")
+	listener, err := net.Listen("unix", bound)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on control socket: %s", err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(bound, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("could not restrict control socket permissions: %s", err)
+	}
+	if err := os.Rename(bound, path); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("could not move control socket: %s", err)
+	}
+	return &controlListener{Listener: listener, path: path}, nil
+}
+
+// checkPrivateDir refuses a control socket directory another user could enter or replace the socket in,
+// as an existing directory is left as is by os.MkdirAll.
+func checkPrivateDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("could not check control socket directory: %s", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("control socket directory %s is not a directory", dir)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("control socket directory %s must only be accessible by its owner, its mode is %s", dir, info.Mode().Perm())
+	}
+	if !ownedByCurrentUser(info) {
+		return fmt.Errorf("control socket directory %s is not owned by the current user", dir)
+	}
+	return nil
+}
+
+// HandleControlConnection answers line delimited json requests until the connection is closed.
+func (chat *Chat) HandleControlConnection(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxControlRequestSize)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var request ControlRequest
+		response := &ControlResponse{}
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			response.Error = fmt.Sprintf("invalid request: %s", err)
+		} else {
+			response = chat.HandleControlRequest(&request)
+		}
+		if err := encoder.Encode(response); err != nil {
+			chat.Logger.Error("failed to write control response", "error", err)
+			return
+		}
+	}
+}
+
+func (chat *Chat) HandleControlRequest(request *ControlRequest) (response *ControlResponse) {
+	response = &ControlResponse{} // named so a recovered panic still returns it
+	defer chat.RecoverControl(request.Command, response)
+	switch request.Command {
+	case ListUsersControl:
+		response.Users = chat.ListUsers()
+	case ListSessionsControl:
+		response.Sessions = chat.ListSessions()
+	case StatsControl:
+		response.Stats = chat.Stats()
+	case BroadcastControl:
+		if request.Content == "" {
+			response.Error = "broadcast content is required"
+			break
+		}
+		chat.BroadcastNotice(request.Content)
+		response.Message = "notice broadcast"
+	case KickControl, BanControl, UnbanControl:
+		var duration time.Duration
+		if request.Duration != "" {
+			var err error
+			if duration, err = time.ParseDuration(request.Duration); err != nil || duration <= 0 {
+				response.Error = fmt.Sprintf("invalid duration \"%s\"", request.Duration)
+				break
+			}
+		}
+		unlock := chat.lock()
+		defer unlock()
+		notice, err := chat.ApplyModeration(adminClient, request.Command, request.Target, duration, request.Reason)
+		unlock()
+		chat.AnnounceKicks()
+		if err != nil {
+			response.Error = err.Error()
+			break
+		}
+		chat.BroadcastNotice(notice)
+		response.Message = notice
+	case PurgeControl:
+		if err := chat.PurgeHistory(); err != nil {
+			response.Error = err.Error()
+			break
+		}
+		chat.BroadcastNotice("Chat history was purged by admin.")
+		response.Message = "history purged"
+	case ExportControl:
+		response.Archive = chat.Export()
+	case ImportControl:
+		if request.Archive == nil {
+			response.Error = "import archive is required"
+			break
+		}
+		summary, err := chat.Import(request.Archive)
+		if err != nil {
+			response.Error = err.Error()
+			break
+		}
+		response.Message = summary
+	default:
+		response.Error = fmt.Sprintf("unknown command \"%s\"", request.Command)
+	}
+	return response
+}
+
+// RecoverControl keeps a panicking control request from crashing the server and answers it with an error,
+// it must be deferred by HandleControlRequest which fills response.
+func (chat *Chat) RecoverControl(command string, response *ControlResponse) {
+	err := recover()
+	if err == nil {
+		return
+	}
+	chat.Metrics.HandlerPanic(command)
+	chat.Logger.Error("control request panicked", "command", command, "error", err, "stack", string(debug.Stack()))
+	*response = ControlResponse{Error: "the server failed to handle the request"}
+}
+
+func (chat *Chat) ListUsers() []*UserInfo {
+	chat.mu.RLock()
+	defer chat.mu.RUnlock()
+	now := time.Now()
+	users := make([]*UserInfo, 0, len(chat.Clients))
+	for _, client := range chat.Clients {
+		ban, banned := chat.Bans[client.ID]
+		users = append(users, &UserInfo{
+			ID:       client.ID,
+			Name:     client.Name,
+			Role:     client.Role,
+			Online:   client.Online,
+			Muted:    client.IsMuted(now),
+			Banned:   banned && ban.Active(now),
+			LastSeen: client.LastSeen,
+		})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users
+}
+
+func (chat *Chat) ListSessions() []*SessionInfo {
+	chat.mu.RLock()
+	defer chat.mu.RUnlock()
+	sessions := make([]*SessionInfo, 0, chat.connected)
+	for _, client := range chat.Clients {
+		if !client.Online {
+			continue
+		}
+		session := &SessionInfo{ID: client.ID, Name: client.Name, LastSeen: client.lastActive}
+		if client.Address != nil {
+			session.Address = client.Address.String()
+		}
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeen.After(sessions[j].LastSeen) })
+	return sessions
+}
+
+func (chat *Chat) Stats() *Stats {
+	chat.mu.RLock()
+	defer chat.mu.RUnlock()
+	return &Stats{
+		Uptime:        time.Since(chat.startedAt).Round(time.Second).String(),
+		Users:         len(chat.Clients),
+		Connected:     chat.connected,
+		HistoryLength: len(chat.History),
+		Bans:          len(chat.Bans),
+		PacketsIn:     chat.Metrics.PacketsIn(),
+		Room:          chat.Room.Name,
+		Retention:     chat.Room.Retention.String(),
+	}
+}
+
+// PurgeHistory deletes all messages from redis and memory along with the deletions, read cursors
+// and synced history pointing at them. Cached clients no longer find their newest message and resync fully.
+func (chat *Chat) PurgeHistory() error {
+	chat.mu.Lock()
+	defer chat.mu.Unlock()
+	ctx := context.Background()
+	epoch := time.Now() // tombstones are purged too, every cache is resent in full
+	pipe := chat.RedisClient.TxPipeline()
+	pipe.Del(ctx, utils.RedisHistoryKey, utils.RedisMessagesKey, utils.RedisDeletionsKey, utils.RedisReadCursorsKey)
+	pipe.Set(ctx, utils.RedisEpochKey, epoch, 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to empty redis history: %s", err)
+	}
+	chat.Epoch = epoch
+	chat.History = make([]*Message, 0)
+	chat.Index = NewSearchIndex(nil)
+	chat.Deletions = map[string]*Tombstone{}
+	chat.ReadCursors = map[string]string{}
+	chat.syncs = map[string][]string{}
+	return nil
+}
+
+// SendControlRequest sends a single request to the control socket of a running server.
+func SendControlRequest(path string, request *ControlRequest) (*ControlResponse, error) {
+	conn, err := net.DialTimeout("unix", path, 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach server control socket: %s", err)
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return nil, fmt.Errorf("could not send control request: %s", err)
+	}
+	var response ControlResponse
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return nil, fmt.Errorf("could not read control response: %s", err)
+	}
+	if response.Error != "" {
+		return &response, fmt.Errorf("%s", response.Error)
+	}
+	return &response, nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package server
+
+import "os"
+
+// ownedByCurrentUser can't tell the owner of a file here, the directory mode is checked alone.
+func ownedByCurrentUser(info os.FileInfo) bool {
+	return true
+}
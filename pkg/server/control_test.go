@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChat_ControlSocket(t *testing.T) {
+	s := StartTestServer(t)
+	controlPath := filepath.Join(t.TempDir(), "control", "control.sock")
+	listener, err := s.Chat.ListenControl(controlPath)
+	if err != nil {
+		t.Fatal("could not listen on control socket: ", err)
+	}
+	defer listener.Close()
+
+	conn := CreateTestConnection(t, s.Addr().String())
+	defer conn.Close()
+	AddTestClient(t, conn, &LoginInput{Username: "tester"})
+
+	t.Run("Listing sessions returns online clients with addresses", func(t *testing.T) {
+		response, err := SendControlRequest(controlPath, &ControlRequest{Command: ListSessionsControl})
+		if err != nil {
+			t.Fatal("control request failed: ", err)
+		}
+		assert.Len(t, response.Sessions, 1)
+		assert.Equal(t, "tester", response.Sessions[0].Name)
+		assert.Equal(t, conn.LocalAddr().String(), response.Sessions[0].Address)
+	})
+
+	t.Run("Broadcasting sends a notice to online clients", func(t *testing.T) {
+		if _, err := SendControlRequest(controlPath, &ControlRequest{Command: BroadcastControl, Content: "maintenance"}); err != nil {
+			t.Fatal("control request failed: ", err)
+		}
+		var notice Notice
+		UnpackTestData(t, ReadTestCommand(t, conn, utils.NoticeCommand), &notice)
+		assert.Equal(t, "maintenance", notice.Content)
+	})
+
+	t.Run("Kicking a client disconnects it", func(t *testing.T) {
+		if _, err := SendControlRequest(controlPath, &ControlRequest{Command: KickControl, Target: "tester"}); err != nil {
+			t.Fatal("control request failed: ", err)
+		}
+		ReadTestCommand(t, conn, utils.KickedCommand)
+		response, err := SendControlRequest(controlPath, &ControlRequest{Command: StatsControl})
+		if err != nil {
+			t.Fatal("control request failed: ", err)
+		}
+		assert.Equal(t, 0, response.Stats.Connected)
+		assert.Equal(t, 1, response.Stats.Users)
+	})
+
+	t.Run("Only the owner can reach the socket", func(t *testing.T) {
+		info, err := os.Stat(controlPath)
+		if err != nil {
+			t.Fatal("could not stat control socket: ", err)
+		}
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		entries, err := os.ReadDir(filepath.Dir(controlPath))
+		if err != nil {
+			t.Fatal("could not read control socket directory: ", err)
+		}
+		assert.Len(t, entries, 1, "temporary socket directory should be removed")
+	})
+
+	t.Run("Purging history clears what pointed at it", func(t *testing.T) {
+		s.Chat.mu.Lock()
+		s.Chat.Deletions["deleted"] = &Tombstone{ID: "deleted"}
+		s.Chat.ReadCursors["reader"] = "read"
+		s.Chat.mu.Unlock()
+		if _, err := SendControlRequest(controlPath, &ControlRequest{Command: PurgeControl}); err != nil {
+			t.Fatal("control request failed: ", err)
+		}
+		s.Chat.mu.RLock()
+		defer s.Chat.mu.RUnlock()
+		assert.Empty(t, s.Chat.History)
+		assert.Empty(t, s.Chat.Deletions)
+		assert.Empty(t, s.Chat.ReadCursors)
+		assert.Empty(t, s.Chat.syncs)
+	})
+
+	t.Run("Panicking requests are answered with an error", func(t *testing.T) {
+		s.Chat.mu.Lock()
+		bans := s.Chat.Bans
+		s.Chat.Bans = nil // saving a ban now panics while holding the chat lock
+		s.Chat.mu.Unlock()
+		_, err := SendControlRequest(controlPath, &ControlRequest{Command: BanControl, Target: "tester"})
+		assert.EqualError(t, err, "the server failed to handle the request")
+		s.Chat.mu.Lock()
+		s.Chat.Bans = bans
+		s.Chat.mu.Unlock()
+		assert.NoError(t, s.RedisClient.Del(context.Background(), utils.RedisBansKey).Err())
+		_, err = SendControlRequest(controlPath, &ControlRequest{Command: StatsControl})
+		assert.NoError(t, err, "the chat lock should be released")
+	})
+
+	t.Run("Unknown commands return an error", func(t *testing.T) {
+		_, err := SendControlRequest(controlPath, &ControlRequest{Command: "reboot"})
+		assert.Error(t, err)
+	})
+}
+
+func TestChat_ListenControl(t *testing.T) {
+	s := StartTestServer(t)
+
+	t.Run("Directories other users can enter are refused", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.Chmod(dir, 0755); err != nil {
+			t.Fatal("could not change directory mode: ", err)
+		}
+		_, err := s.Chat.ListenControl(filepath.Join(dir, "control.sock"))
+		assert.Error(t, err)
+	})
+
+	t.Run("Files other than sockets are not replaced", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "control")
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatal("could not create directory: ", err)
+		}
+		path := filepath.Join(dir, "control.sock")
+		if err := os.WriteFile(path, []byte("keep"), 0600); err != nil {
+			t.Fatal("could not write file: ", err)
+		}
+		_, err := s.Chat.ListenControl(path)
+		assert.Error(t, err)
+		content, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "keep", string(content))
+	})
+
+	t.Run("Stale sockets are replaced", func(t *testing.T) {
+		dir, err := os.MkdirTemp("", "udp-chat-") // short enough for a socket path
+		if err != nil {
+			t.Fatal("could not create directory: ", err)
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "control", "control.sock")
+		stale, err := s.Chat.ListenControl(path)
+		if err != nil {
+			t.Fatal("could not listen on control socket: ", err)
+		}
+		stale.(*controlListener).Listener.Close() // leaves the socket file behind like a crashed server
+		listener, err := s.Chat.ListenControl(path)
+		if err != nil {
+			t.Fatal("could not replace stale control socket: ", err)
+		}
+		listener.Close()
+	})
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"log/slog"
+	"time"
+)
+
+// Tombstone records a deleted message so archives and client caches can remove it too.
+type Tombstone struct {
+	ID        string    `json:"id"`
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy string    `json:"deleted_by,omitempty"`
+}
+
+func (t *Tombstone) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, t)
+}
+
+func FetchDeletionsFromRedis(redisClient *redis.Client, logger *slog.Logger) map[string]*Tombstone {
+	deletions := map[string]*Tombstone{}
+	entries, err := redisClient.HGetAll(context.Background(), utils.RedisDeletionsKey).Result()
+	if err != nil && err != redis.Nil {
+		logger.Error("could not fetch redis deletions", "error", err)
+		return deletions
+	}
+	for id, entry := range entries {
+		var tombstone Tombstone
+		if err := json.Unmarshal([]byte(entry), &tombstone); err != nil {
+			logger.Error("could not unmarshal deletion", "message_id", id, "error", err)
+			continue
+		}
+		deletions[id] = &tombstone
+	}
+	return deletions
+}
+
+// SaveTombstone stores the deletion of a message. Callers must hold the chat lock.
+func (chat *Chat) SaveTombstone(tombstone *Tombstone) error {
+	bytes, err := json.Marshal(tombstone)
+	if err != nil {
+		return fmt.Errorf("failed to marshal deletion: %s", err)
+	}
+	if err := chat.RedisClient.HSet(context.Background(), utils.RedisDeletionsKey, tombstone.ID, string(bytes)).Err(); err != nil {
+		return fmt.Errorf("could not save deletion to redis: %s", err)
+	}
+	chat.Deletions[tombstone.ID] = tombstone
+	return nil
+}
+
+// StaleTombstones returns the ids of messages deleted before epoch, caches older than it are resent
+// in full so their tombstones are no longer needed. Callers must hold the chat lock.
+func (chat *Chat) StaleTombstones(epoch time.Time) []string {
+	ids := make([]string, 0)
+	for id, tombstone := range chat.Deletions {
+		if tombstone.DeletedAt.Before(epoch) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
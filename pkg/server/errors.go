@@ -0,0 +1,90 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"net"
+	"runtime/debug"
+	"strings"
+)
+
+// Error codes tell clients why a request was rejected.
+const (
+	InvalidRequestCode = "invalid_request" // packet could not be decoded, misses a field or holds an invalid value
+	InvalidContentCode = "invalid_content" // message, nickname or reaction is empty, too long or malformed
+	UnknownClientCode  = "unknown_client"  // sender is not registered or offline
+	NotFoundCode       = "not_found"       // the message or user the request is about doesnt exist
+	ForbiddenCode      = "forbidden"       // sender is not allowed to do this, or is muted
+	ConflictCode       = "conflict"        // the nickname is owned by another client
+	UnknownCommandCode = "unknown_command"
+	InternalErrorCode  = "internal" // storage failure or handler panic, the request can be sent again
+)
+
+// ErrorPacket tells a client that one of its requests failed.
+type ErrorPacket struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Request string `json:"request,omitempty"` // command of the failed request
+}
+
+func (e *ErrorPacket) Error() string {
+	if e.Request == "" {
+		return fmt.Sprintf("%s (%s)", e.Message, e.Code)
+	}
+	return fmt.Sprintf("%s (%s %s)", e.Message, strings.Trim(e.Request, "/>"), e.Code)
+}
+
+// RequestError is the reason a request was rejected along with the code sent to the client.
+type RequestError struct {
+	Code string
+	Err  error
+}
+
+func (e *RequestError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// rejection returns a RequestError with a formatted reason.
+func rejection(code string, format string, args ...interface{}) error {
+	return &RequestError{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// ErrorCode returns the code of a RequestError, other errors are internal.
+func ErrorCode(err error) string {
+	var requestErr *RequestError
+	if errors.As(err, &requestErr) {
+		return requestErr.Code
+	}
+	return InternalErrorCode
+}
+
+// sentence capitalises the reason of err to be shown to a client.
+func sentence(err error) string {
+	reason := err.Error()
+	if reason == "" {
+		return reason
+	}
+	return strings.ToUpper(reason[:1]) + reason[1:] + "."
+}
+
+// RecoverHandler keeps a panicking handler of command from crashing the server, it must be deferred by the
+// goroutine running the handler. Handlers release the chat lock with a deferred unlock so it is not left held.
+func (chat *Chat) RecoverHandler(command string, addr *net.UDPAddr) {
+	err := recover()
+	if err == nil {
+		return
+	}
+	chat.Metrics.HandlerPanic(command)
+	chat.Logger.Error("handler panicked", "addr", addr.String(), "command", command, "error", err, "stack", string(debug.Stack()))
+	chat.SendError(addr, command, InternalErrorCode, "The server failed to handle the request.")
+}
+
+// SendError tells addr its request of command was rejected, the reason is logged by the caller.
+func (chat *Chat) SendError(addr *net.UDPAddr, command string, code string, message string) {
+	chat.SendToAddress(addr, utils.ErrorCommand, &ErrorPacket{Code: code, Message: message, Request: command})
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestChat_RecoverHandler(t *testing.T) {
+	s := StartTestServer(t)
+	conn := CreateTestConnection(t, s.Addr().String())
+	defer conn.Close()
+	client := AddTestClient(t, conn, &LoginInput{Username: "alice"})
+	SendTestMessage(t, conn, &Message{Content: "hello", AuthorID: client.AssignedId})
+
+	s.Chat.mu.Lock()
+	index := s.Chat.Index
+	s.Chat.Index = nil // searching now panics while holding the chat lock
+	s.Chat.mu.Unlock()
+	if err := utils.WriteToUDPConn(conn, utils.SearchCommand, &SearchInput{ClientID: client.AssignedId, Query: "hello"}); err != nil {
+		t.Fatal("could not write to UDP connection: ", err)
+	}
+	var packet ErrorPacket
+	UnpackTestData(t, ReadTestCommand(t, conn, utils.ErrorCommand), &packet)
+	assert.Equal(t, InternalErrorCode, packet.Code)
+	assert.Equal(t, utils.SearchCommand, packet.Request)
+
+	released := make(chan bool)
+	go func() {
+		s.Chat.mu.Lock()
+		s.Chat.Index = index
+		s.Chat.mu.Unlock()
+		close(released)
+	}()
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("the chat lock was left held by the panicking handler")
+	}
+	results := SearchTest(t, conn, &SearchInput{ClientID: client.AssignedId, Query: "hello"})
+	assert.Len(t, results.Messages, 1, "the server should keep handling requests")
+
+	var metrics bytes.Buffer
+	s.Chat.WriteMetrics(&metrics)
+	assert.Contains(t, metrics.String(), `udp_chat_handler_panics_total{command="`+utils.SearchCommand+`"} 1`)
+}
+
+func TestChat_SendError(t *testing.T) {
+	s := StartTestServer(t)
+	conn := CreateTestConnection(t, s.Addr().String())
+	defer conn.Close()
+	alice := AddTestClient(t, conn, &LoginInput{Username: "alice"})
+	bobConn := CreateTestConnection(t, s.Addr().String())
+	defer bobConn.Close()
+	bob := AddTestClient(t, bobConn, &LoginInput{Username: "bob"})
+	message := SendTestMessage(t, conn, &Message{Content: "hello", AuthorID: alice.AssignedId})
+	carolConn := CreateTestConnection(t, s.Addr().String())
+	defer carolConn.Close()
+	carol := AddTestClient(t, carolConn, &LoginInput{Username: "carol"})
+	unlock := s.Chat.lock()
+	assert.NoError(t, s.Chat.UpdateClient(s.Chat.Clients[carol.AssignedId], func(c *Client) { c.Muted = true }))
+	unlock()
+	daveConn := CreateTestConnection(t, s.Addr().String())
+	defer daveConn.Close()
+	dave := AddTestClient(t, daveConn, &LoginInput{Username: "dave"})
+	DisconnectTestClient(t, daveConn, dave.AssignedId)
+
+	for _, test := range []struct {
+		name    string
+		conn    *net.UDPConn
+		command string
+		data    interface{}
+		code    string
+	}{
+		{"Undecodable requests are invalid", conn, utils.AddReactionCommand, "not an object", InvalidRequestCode},
+		{"Undecodable logins are invalid", conn, utils.ConnectCommand, "not an object", InvalidRequestCode},
+		{"Messages of unknown clients are rejected", conn, utils.AddMessageCommand, &Message{Content: "hi", AuthorID: "unknown"}, UnknownClientCode},
+		{"Empty messages are invalid content", conn, utils.AddMessageCommand, &Message{Content: "  ", AuthorID: alice.AssignedId}, InvalidContentCode},
+		{"Deleting a missing message is not found", conn, utils.DeleteMessageCommand, &Message{ID: "missing", AuthorID: alice.AssignedId}, NotFoundCode},
+		{"Deleting messages of others is forbidden", bobConn, utils.DeleteMessageCommand, &Message{ID: message.ID, AuthorID: bob.AssignedId}, ForbiddenCode},
+		{"Unknown commands are reported", conn, "/bogus>", struct{}{}, UnknownCommandCode},
+		{"Muted clients cannot send messages", carolConn, utils.AddMessageCommand, &Message{Content: "hi", AuthorID: carol.AssignedId}, ForbiddenCode},
+		{"Replying to a missing message is not found", conn, utils.AddMessageCommand, &Message{Content: "hi", AuthorID: alice.AssignedId, ReplyTo: "missing"}, NotFoundCode},
+		{"Invalid reactions are invalid content", conn, utils.AddReactionCommand, &ReactionInput{ClientID: alice.AssignedId, MessageID: message.ID, Emoji: "[red]x"}, InvalidContentCode},
+		{"Reacting to a missing message is not found", conn, utils.AddReactionCommand, &ReactionInput{ClientID: alice.AssignedId, MessageID: "missing", Emoji: ":thumbsup:"}, NotFoundCode},
+		{"Taking the nickname of another client is a conflict", conn, utils.NickCommand, &NickInput{ClientID: alice.AssignedId, Name: "BOB"}, ConflictCode},
+		{"Invalid nicknames are invalid content", conn, utils.NickCommand, &NickInput{ClientID: alice.AssignedId, Name: "a b"}, InvalidContentCode},
+		{"Whois of an unknown user is not found", conn, utils.WhoisCommand, &WhoisInput{ClientID: alice.AssignedId, Name: "nobody"}, NotFoundCode},
+		{"Invalid moderation durations are invalid", conn, utils.ModerateCommand, &ModerationInput{IssuerID: alice.AssignedId, Action: MuteAction, Target: "bob", Duration: "soon"}, InvalidRequestCode},
+		{"Members cannot moderate", bobConn, utils.ModerateCommand, &ModerationInput{IssuerID: bob.AssignedId, Action: KickAction, Target: "alice"}, ForbiddenCode},
+		{"Moderating an unknown user is not found", conn, utils.ModerateCommand, &ModerationInput{IssuerID: alice.AssignedId, Action: KickAction, Target: "nobody"}, NotFoundCode},
+		{"Marking an unknown message as read is not found", conn, utils.MarkReadCommand, &ReadInput{ClientID: alice.AssignedId, MessageID: "missing"}, NotFoundCode},
+		{"Disconnecting twice is rejected", daveConn, utils.DisconnectCommand, dave.AssignedId, UnknownClientCode},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := utils.WriteToUDPConn(test.conn, test.command, test.data); err != nil {
+				t.Fatal("could not write to UDP connection: ", err)
+			}
+			var packet ErrorPacket
+			UnpackTestData(t, ReadTestCommand(t, test.conn, utils.ErrorCommand), &packet)
+			assert.Equal(t, test.code, packet.Code)
+			assert.Equal(t, test.command, packet.Request)
+			assert.NotEmpty(t, packet.Message)
+		})
+	}
+	unlock = s.Chat.rlock()
+	assert.Len(t, s.Chat.Clients, 4, "rejected requests should not register clients")
+	unlock()
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bytes"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+)
+
+// fuzzSeeds are valid inputs of every command handled by the chat, "$client" and "$message" are replaced
+// by the id of the fuzzing client and of a stored message so inputs reach past the lookups.
+var fuzzSeeds = map[string][]string{
+	utils.ConnectCommand: {
+		`{"username":"bob"}`,
+		`{"username":"alice","assigned_id":"$client","last_message_id":"$message","cached_at":"2022-01-01T00:00:00Z"}`,
+	},
+	utils.AddMessageCommand: {
+		`{"content":"hello @alice","author_id":"$client"}`,
+		`{"content":"hi","author_id":"$client","reply_to":"$message"}`,
+	},
+	utils.DeleteMessageCommand:  {`{"id":"$message","author_id":"$client"}`},
+	utils.DisconnectCommand:     {`$client`},
+	utils.ModerateCommand:       {`{"issuer_id":"$client","action":"mute","target":"alice","duration":"1m"}`},
+	utils.AwayCommand:           {`{"client_id":"$client","away":true,"message":"brb"}`},
+	utils.WhoisCommand:          {`{"client_id":"$client","name":"alice"}`},
+	utils.NickCommand:           {`{"client_id":"$client","name":"alicia"}`},
+	utils.TypingCommand:         {`{"client_id":"$client","typing":true}`},
+	utils.AddReactionCommand:    {`{"client_id":"$client","message_id":"$message","emoji":":thumbsup:"}`},
+	utils.RemoveReactionCommand: {`{"client_id":"$client","message_id":"$message","emoji":":wave:"}`},
+	utils.MentionsCommand:       {`{"client_id":"$client"}`},
+	utils.MarkReadCommand:       {`{"client_id":"$client","message_id":"$message"}`},
+	utils.HistoryPageCommand:    {`{"client_id":"$client","before":"$message","limit":5}`},
+	utils.SearchCommand:         {`{"client_id":"$client","query":"from:alice hello","offset":1}`},
+	utils.ResendHistoryCommand:  {`{"client_id":"$client","orders":[0,-1,99]}`},
+}
+
+// StartFuzzChat starts a chat with a registered owner and one message it reacted to, returning the owner address and ids.
+func StartFuzzChat(f *testing.F) (*Chat, *net.UDPAddr, string, string) {
+	s := NewTestServer(f)
+	s.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	RunTestServer(f, s)
+	conn := CreateTestConnection(f, s.Addr().String())
+	f.Cleanup(func() { conn.Close() })
+	payload := AddTestClient(f, conn, &LoginInput{Username: "alice"})
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	s.Chat.HandlePacket([]byte(utils.AddMessageCommand+`{"content":"hello","author_id":"`+payload.AssignedId+`"}`), addr)
+	s.Chat.mu.RLock()
+	messageID := s.Chat.History[0].ID
+	s.Chat.mu.RUnlock()
+	s.Chat.HandlePacket([]byte(utils.AddReactionCommand+`{"client_id":"`+payload.AssignedId+`","message_id":"`+messageID+`","emoji":":wave:"}`), addr)
+	return s.Chat, addr, payload.AssignedId, messageID
+}
+
+// fillFuzzIDs replaces the id placeholders of fuzzSeeds.
+func fillFuzzIDs(data []byte, clientID, messageID string) []byte {
+	data = bytes.ReplaceAll(data, []byte("$client"), []byte(clientID))
+	return bytes.ReplaceAll(data, []byte("$message"), []byte(messageID))
+}
+
+// CheckTestState fails when the chat state breaks an invariant the handlers rely on.
+func CheckTestState(t *testing.T, chat *Chat) {
+	chat.mu.RLock()
+	defer chat.mu.RUnlock()
+	online := 0
+	for id, client := range chat.Clients {
+		if client == nil || client.ID != id {
+			t.Fatalf("client %q is stored under another id", id)
+		}
+		if client.Online {
+			online += 1
+		}
+	}
+	if online != chat.connected {
+		t.Fatalf("%d clients are online but %d are counted as connected", online, chat.connected)
+	}
+	ids := map[string]bool{}
+	for _, message := range chat.History {
+		if message == nil || message.ID == "" || ids[message.ID] {
+			t.Fatalf("history holds a message without a unique id: %+v", message)
+		}
+		ids[message.ID] = true
+		if _, ok := chat.Clients[message.AuthorID]; !ok {
+			t.Fatalf("message %s has no registered author", message.ID)
+		}
+	}
+}
+
+// FuzzChat_HandlePacket feeds whole packets, reaching the command routing and unknown commands.
+func FuzzChat_HandlePacket(f *testing.F) {
+	for command, seeds := range fuzzSeeds {
+		for _, seed := range seeds {
+			f.Add([]byte(command + seed))
+		}
+	}
+	chat, addr, clientID, messageID := StartFuzzChat(f)
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		chat.HandlePacket(fillFuzzIDs(packet, clientID, messageID), addr)
+		CheckTestState(t, chat)
+	})
+}
+
+// RunFuzzHandler fuzzes the handler of command with arbitrary data, seeded with its valid inputs.
+func RunFuzzHandler(f *testing.F, command string) {
+	for _, seed := range fuzzSeeds[command] {
+		f.Add([]byte(seed))
+	}
+	chat, addr, clientID, messageID := StartFuzzChat(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		chat.HandlePacket(append([]byte(command), fillFuzzIDs(data, clientID, messageID)...), addr)
+		CheckTestState(t, chat)
+	})
+}
+
+func FuzzChat_Join(f *testing.F)              { RunFuzzHandler(f, utils.ConnectCommand) }
+func FuzzChat_AddMessage(f *testing.F)        { RunFuzzHandler(f, utils.AddMessageCommand) }
+func FuzzChat_DeleteMessage(f *testing.F)     { RunFuzzHandler(f, utils.DeleteMessageCommand) }
+func FuzzChat_Disconnect(f *testing.F)        { RunFuzzHandler(f, utils.DisconnectCommand) }
+func FuzzChat_Moderate(f *testing.F)          { RunFuzzHandler(f, utils.ModerateCommand) }
+func FuzzChat_SetAway(f *testing.F)           { RunFuzzHandler(f, utils.AwayCommand) }
+func FuzzChat_Whois(f *testing.F)             { RunFuzzHandler(f, utils.WhoisCommand) }
+func FuzzChat_ChangeNickname(f *testing.F)    { RunFuzzHandler(f, utils.NickCommand) }
+func FuzzChat_Typing(f *testing.F)            { RunFuzzHandler(f, utils.TypingCommand) }
+func FuzzChat_AddReaction(f *testing.F)       { RunFuzzHandler(f, utils.AddReactionCommand) }
+func FuzzChat_RemoveReaction(f *testing.F)    { RunFuzzHandler(f, utils.RemoveReactionCommand) }
+func FuzzChat_SendMentions(f *testing.F)      { RunFuzzHandler(f, utils.MentionsCommand) }
+func FuzzChat_MarkRead(f *testing.F)          { RunFuzzHandler(f, utils.MarkReadCommand) }
+func FuzzChat_SendHistoryPage(f *testing.F)   { RunFuzzHandler(f, utils.HistoryPageCommand) }
+func FuzzChat_SendSearchResults(f *testing.F) { RunFuzzHandler(f, utils.SearchCommand) }
+func FuzzChat_ResendHistory(f *testing.F)     { RunFuzzHandler(f, utils.ResendHistoryCommand) }
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"net"
+	"time"
+)
+
+const (
+	DefaultPageLimit = 30  // messages per page when the request doesn't specify a limit
+	MaxPageLimit     = 100 // most messages sent for a single page
+	LatestPage       = "latest"
+)
+
+type HistoryPageInput struct {
+	ClientID string `json:"client_id"`
+	Before   string `json:"before,omitempty"` // id of the oldest message the client has, empty or "latest" for the latest page
+	Limit    int    `json:"limit,omitempty"`
+}
+
+// HistoryPage announces how many history logs follow for a page request.
+type HistoryPage struct {
+	Before  string `json:"before"` // cursor of the request, also set on each following history log
+	Length  int    `json:"length"`
+	HasMore bool   `json:"has_more"` // older messages exist before this page
+}
+
+// SendHistoryPage sends the messages preceding the before cursor as ordered history logs.
+func (chat *Chat) SendHistoryPage(data []byte, addr *net.UDPAddr) {
+	var input HistoryPageInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		chat.Metrics.DecodeError()
+		chat.Logger.Warn("failed to unmarshal history page input", "addr", addr.String(), "error", err)
+		chat.SendError(addr, utils.HistoryPageCommand, InvalidRequestCode, "The request could not be decoded.")
+		return
+	}
+	if input.Before == "" {
+		input.Before = LatestPage
+	}
+	if input.Limit <= 0 {
+		input.Limit = DefaultPageLimit
+	}
+	if input.Limit > MaxPageLimit {
+		input.Limit = MaxPageLimit
+	}
+	unlock := chat.rlock()
+	defer unlock()
+	client, ok := chat.Clients[input.ClientID]
+	if !ok || !client.Online {
+		unlock()
+		chat.Logger.Warn("unrecognized client", "addr", addr.String(), "client_id", input.ClientID)
+		chat.SendError(addr, utils.HistoryPageCommand, UnknownClientCode, "You are not connected, reconnect to continue.")
+		return
+	}
+	end := len(chat.History)
+	if input.Before != LatestPage {
+		// a cursor missing from history was removed with every older message
+		end = chat.MessageIndex(input.Before)
+		if end == -1 {
+			end = 0
+		}
+	}
+	start := end - input.Limit
+	if start < 0 {
+		start = 0
+	}
+	names := chat.ClientNames()
+	messages := make([]*Message, 0, end-start)
+	for _, message := range chat.History[start:end] {
+		messages = append(messages, message.ForClient(client.ID, names))
+	}
+	unlock()
+
+	page := &HistoryPage{Before: input.Before, Length: len(messages), HasMore: start > 0}
+	client.Send(utils.HistoryPageCommand, page)
+	for i, message := range messages {
+		if i != 0 && i%HistoryPageSize == 0 {
+			time.Sleep(HistoryPageWait)
+		}
+		historyLog := &HistoryLog{Order: i, Message: message, Page: input.Before}
+		client.Send(utils.AddHistoryCommand, historyLog)
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"net"
+	"testing"
+)
+
+// RequestTestPage requests a history page and collects its logs in order.
+func RequestTestPage(t *testing.T, conn *net.UDPConn, input *HistoryPageInput) (*HistoryPage, []*Message) {
+	t.Helper()
+	if err := utils.WriteToUDPConn(conn, utils.HistoryPageCommand, input); err != nil {
+		t.Fatal("could not write to UDP connection: ", err)
+	}
+	var page HistoryPage
+	UnpackTestData(t, ReadTestCommand(t, conn, utils.HistoryPageCommand), &page)
+	messages := make([]*Message, page.Length)
+	for i := 0; i < page.Length; i++ {
+		var historyLog HistoryLog
+		UnpackTestData(t, ReadTestCommand(t, conn, utils.AddHistoryCommand), &historyLog)
+		assert.Equal(t, page.Before, historyLog.Page)
+		messages[historyLog.Order] = historyLog.Message
+	}
+	return &page, messages
+}
+
+func TestChat_HistoryPages(t *testing.T) {
+	s := StartTestServer(t)
+	conn := CreateTestConnection(t, s.Addr().String())
+	defer conn.Close()
+	client := AddTestClient(t, conn, &LoginInput{Username: "alice"})
+	var last *Message
+	for i := 1; i <= 5; i++ {
+		last = SendTestMessage(t, conn, &Message{Content: fmt.Sprintf("message %d", i), AuthorID: client.AssignedId})
+	}
+
+	t.Run("Pages end right before the cursor", func(t *testing.T) {
+		page, messages := RequestTestPage(t, conn, &HistoryPageInput{ClientID: client.AssignedId, Before: last.ID, Limit: 2})
+		assert.Equal(t, 2, page.Length)
+		assert.True(t, page.HasMore)
+		assert.Equal(t, "message 3", messages[0].Content)
+		assert.Equal(t, "message 4", messages[1].Content)
+		assert.Equal(t, client.AssignedId, messages[0].AuthorID)
+		assert.Equal(t, "alice", messages[0].AuthorName)
+	})
+
+	t.Run("Latest page is sent without a cursor", func(t *testing.T) {
+		page, messages := RequestTestPage(t, conn, &HistoryPageInput{ClientID: client.AssignedId, Limit: 10})
+		assert.Equal(t, LatestPage, page.Before)
+		assert.False(t, page.HasMore)
+		assert.Len(t, messages, 5)
+		assert.Equal(t, "message 5", messages[4].Content)
+	})
+
+	t.Run("Unknown cursors have nothing older", func(t *testing.T) {
+		page, _ := RequestTestPage(t, conn, &HistoryPageInput{ClientID: client.AssignedId, Before: "missing"})
+		assert.Equal(t, 0, page.Length)
+		assert.False(t, page.HasMore)
+	})
+}
+
+func TestFetchHistoryFromRedis(t *testing.T) {
+	s := NewTestServer(t)
+	ctx := context.Background()
+	first := &Message{ID: "first", Content: "hello", AuthorID: "alice"}
+	second := &Message{ID: "second", Content: "world", AuthorID: "bob"}
+
+	t.Run("Messages stored in the history list are migrated", func(t *testing.T) {
+		for _, message := range []*Message{first, second} {
+			bytes, _ := json.Marshal(message)
+			if err := s.RedisClient.RPush(ctx, utils.RedisHistoryKey, string(bytes)).Err(); err != nil {
+				t.Fatal("could not push legacy history entry: ", err)
+			}
+		}
+		history := FetchHistoryFromRedis(s.RedisClient, slog.Default())
+		assert.Equal(t, []*Message{first, second}, history)
+		ids, _ := s.RedisClient.LRange(ctx, utils.RedisHistoryKey, 0, -1).Result()
+		assert.Equal(t, []string{"first", "second"}, ids)
+		count, _ := s.RedisClient.HLen(ctx, utils.RedisMessagesKey).Result()
+		assert.EqualValues(t, 2, count)
+	})
+
+	t.Run("Messages are read back by id in order", func(t *testing.T) {
+		assert.Equal(t, []*Message{first, second}, FetchHistoryFromRedis(s.RedisClient, slog.Default()))
+	})
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const (
+	TextLogFormat = "text"
+	JSONLogFormat = "json"
+)
+
+// NewLogger creates a logger writing records from level on to w, as logfmt text or json lines.
+func NewLogger(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{Level: level}
+	switch format {
+	case TextLogFormat:
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case JSONLogFormat:
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	}
+	return nil, fmt.Errorf("invalid log format \"%s\", use %s or %s", format, TextLogFormat, JSONLogFormat)
+}
+
+// ParseLogLevel parses debug, info, warn or error.
+func ParseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.ToUpper(s))); err != nil {
+		return level, fmt.Errorf("invalid log level \"%s\", use debug, info, warn or error", s)
+	}
+	return level, nil
+}
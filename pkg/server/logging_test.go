@@ -0,0 +1,85 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer collects log output written from several goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Records(t *testing.T) []map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	records := make([]map[string]interface{}, 0)
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid json log line %q: %s", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for input, expected := range map[string]slog.Level{"debug": slog.LevelDebug, "info": slog.LevelInfo, "WARN": slog.LevelWarn, "error": slog.LevelError} {
+		level, err := ParseLogLevel(input)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, level)
+	}
+	_, err := ParseLogLevel("verbose")
+	assert.Error(t, err)
+	_, err = NewLogger(&bytes.Buffer{}, "xml", slog.LevelInfo)
+	assert.Error(t, err)
+}
+
+func TestChat_DebugLogging(t *testing.T) {
+	s := NewTestServer(t)
+	output := &lockedBuffer{}
+	var err error
+	if s.Logger, err = NewLogger(output, JSONLogFormat, slog.LevelDebug); err != nil {
+		t.Fatal("error creating logger: ", err)
+	}
+	s.Room = NewRoom("lobby")
+	RunTestServer(t, s)
+
+	conn := CreateTestConnection(t, s.Addr().String())
+	defer conn.Close()
+	client := AddTestClient(t, conn, &LoginInput{Username: "alice"})
+	time.Sleep(100 * time.Millisecond) // connection is logged after the payload is sent
+
+	var packet, connected map[string]interface{}
+	for _, record := range output.Records(t) {
+		assert.Equal(t, "lobby", record["room"], "every record should carry the room")
+		switch record["msg"] {
+		case "packet received":
+			packet = record
+		case "client connected":
+			connected = record
+		}
+	}
+	if assert.NotNil(t, packet, "decoded packets should be logged in debug mode") {
+		assert.Equal(t, utils.ConnectCommand, packet["command"])
+		assert.Equal(t, conn.LocalAddr().String(), packet["addr"])
+	}
+	if assert.NotNil(t, connected) {
+		assert.Equal(t, client.AssignedId, connected["client_id"])
+	}
+}
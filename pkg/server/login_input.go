@@ -1,6 +1,10 @@
 package server
 
+import "time"
+
 type LoginInput struct {
-	Username   string `json:"username,omitempty"`
-	AssignedId string `json:"assigned_id,omitempty"`
+	Username      string    `json:"username,omitempty"`
+	AssignedId    string    `json:"assigned_id,omitempty"`
+	LastMessageID string    `json:"last_message_id,omitempty"` // newest message cached by the client
+	CachedAt      time.Time `json:"cached_at,omitempty"`       // SyncedAt of the initial payload the cache was built from
 }
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"net"
+	"regexp"
+)
+
+// MentionsLimit is the most recent mentions sent on request, fewer are sent when they don't fit in a packet.
+const MentionsLimit = 20
+
+var mentionReg = regexp.MustCompile(`@([A-Za-z0-9_-]+)`)
+
+type MentionsInput struct {
+	ClientID string `json:"client_id"`
+}
+
+type MentionsPayload struct {
+	Messages []*Message `json:"messages"`
+}
+
+// ParseMentions returns ids of known clients mentioned in content. Callers must hold the chat lock.
+func (chat *Chat) ParseMentions(content string) []string {
+	mentions := make([]string, 0)
+	seen := map[string]bool{}
+	for _, match := range mentionReg.FindAllStringSubmatch(content, -1) {
+		client := chat.FindClientByName(match[1])
+		if client == nil || seen[client.ID] {
+			continue
+		}
+		seen[client.ID] = true
+		mentions = append(mentions, client.ID)
+	}
+	if len(mentions) == 0 {
+		return nil
+	}
+	return mentions
+}
+
+// Mentions reports whether message mentions the client.
+func (m *Message) Mentions(clientID string) bool {
+	for _, id := range m.MentionIDs {
+		if id == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// mentionsFor hides mentioned ids other than clientID.
+func (m *Message) mentionsFor(clientID string) []string {
+	if m.Mentions(clientID) {
+		return []string{clientID}
+	}
+	return nil
+}
+
+// SendMentions sends the recent messages mentioning a client, including the ones sent while it was offline.
+func (chat *Chat) SendMentions(data []byte, addr *net.UDPAddr) {
+	var input MentionsInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		chat.Metrics.DecodeError()
+		chat.Logger.Warn("failed to unmarshal mentions input", "addr", addr.String(), "error", err)
+		chat.SendError(addr, utils.MentionsCommand, InvalidRequestCode, "The request could not be decoded.")
+		return
+	}
+	unlock := chat.rlock()
+	defer unlock()
+	client, ok := chat.Clients[input.ClientID]
+	if !ok || !client.Online {
+		unlock()
+		chat.Logger.Warn("unrecognized client", "addr", addr.String(), "client_id", input.ClientID)
+		chat.SendError(addr, utils.MentionsCommand, UnknownClientCode, "You are not connected, reconnect to continue.")
+		return
+	}
+	names := chat.ClientNames()
+	newest := make([]*Message, 0)
+	for i := len(chat.History) - 1; i >= 0 && len(newest) < MentionsLimit; i-- {
+		if chat.History[i].Mentions(client.ID) {
+			newest = append(newest, chat.History[i].ForClient(client.ID, names))
+		}
+	}
+	unlock()
+	payload := &MentionsPayload{Messages: make([]*Message, 0)}
+	newest = newest[:FitMessages(utils.MentionsCommand, payload, newest)]
+	for i := len(newest) - 1; i >= 0; i-- {
+		payload.Messages = append(payload.Messages, newest[i])
+	}
+	client.Send(utils.MentionsCommand, payload)
+}
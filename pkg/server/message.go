@@ -1,12 +1,83 @@
 package server
 
-import "time"
+import (
+	"encoding/json"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"time"
+)
 
 type Message struct {
-	ID         string    `json:"id"`
-	Content    string    `json:"content"`
-	AuthorName string    `json:"author_name"`
-	AuthorID   string    `json:"author_id,omitempty"`
-	CreatedAt  time.Time `json:"created_at"`
-	Edited     bool      `json:"edited"`
+	ID         string              `json:"id"`
+	Content    string              `json:"content"`
+	AuthorName string              `json:"author_name"`
+	AuthorID   string              `json:"author_id,omitempty"`
+	CreatedAt  time.Time           `json:"created_at"`
+	Edited     bool                `json:"edited"`
+	ReplyTo    string              `json:"reply_to,omitempty"`  // id of the message being replied to
+	Reactions  map[string][]string `json:"reactions,omitempty"` // emoji to reacting client ids, names when sent to clients
+	MentionIDs []string            `json:"mentions,omitempty"`  // mentioned client ids, only the recipient id is sent to clients
+	UpdatedAt  time.Time           `json:"updated_at"`          // last edit or reaction, zero when never updated
+}
+
+// UnmarshalBinary lets redis scan history entries into messages.
+func (m *Message) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, m)
+}
+
+// ForClient copies message as it is shown to client, authors and reactions are resolved to names
+// and other clients ids are hidden.
+func (m *Message) ForClient(clientID string, names map[string]string) *Message {
+	message := *m // copy to avoid mutating message in history
+	authorName := DefaultNickname
+	if name, ok := names[m.AuthorID]; ok {
+		authorName = name
+	}
+	message.AuthorName = authorName // author name to message to be identified by other clients
+	if message.AuthorID != clientID {
+		message.AuthorID = ""
+	}
+	message.MentionIDs = m.mentionsFor(clientID)
+	if len(m.Reactions) != 0 {
+		message.Reactions = make(map[string][]string, len(m.Reactions))
+		for emoji, ids := range m.Reactions {
+			reactors := make([]string, 0, len(ids))
+			for _, id := range ids {
+				if name, ok := names[id]; ok {
+					reactors = append(reactors, name)
+				}
+			}
+			message.Reactions[emoji] = reactors
+		}
+	}
+	return &message
+}
+
+// FitMessages returns how many of messages fit in a single packet of command once added to envelope,
+// which is encoded as it is and must not hold any of them yet.
+func FitMessages(command string, envelope interface{}, messages []*Message) int {
+	bytes, err := json.Marshal(envelope)
+	if err != nil {
+		return 0
+	}
+	size := len(command) + len(bytes)
+	for i, message := range messages {
+		encoded, err := json.Marshal(message)
+		if err != nil {
+			return i
+		}
+		size += len(encoded) + 1 // separating comma
+		if size > utils.MaxPacketSize {
+			return i
+		}
+	}
+	return len(messages)
+}
+
+// ClientNames maps client ids to names. Callers must hold the chat lock.
+func (chat *Chat) ClientNames() map[string]string {
+	names := make(map[string]string, len(chat.Clients))
+	for id, c := range chat.Clients {
+		names[id] = c.Name
+	}
+	return names
 }
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+// SendTestMessage sends a message and waits for its broadcast back to the author.
+func SendTestMessage(t *testing.T, conn *net.UDPConn, message *Message) *Message {
+	t.Helper()
+	if err := utils.WriteToUDPConn(conn, utils.AddMessageCommand, message); err != nil {
+		t.Error("could not write to UDP connection: ", err)
+	}
+	var received Message
+	UnpackTestData(t, ReadTestCommand(t, conn, utils.AddMessageCommand), &received)
+	return &received
+}
+
+func TestChat_Replies(t *testing.T) {
+	s := StartTestServer(t)
+	conn := CreateTestConnection(t, s.Addr().String())
+	defer conn.Close()
+	client := AddTestClient(t, conn, &LoginInput{Username: "alice"})
+
+	parent := SendTestMessage(t, conn, &Message{Content: "question", AuthorID: client.AssignedId})
+
+	t.Run("Replying to an existing message keeps the parent id", func(t *testing.T) {
+		reply := SendTestMessage(t, conn, &Message{Content: "answer", AuthorID: client.AssignedId, ReplyTo: parent.ID})
+		assert.Equal(t, parent.ID, reply.ReplyTo)
+	})
+
+	t.Run("Fields owned by the server are not taken from the client", func(t *testing.T) {
+		forged := &Message{Content: "forged", AuthorID: client.AssignedId, AuthorName: "mallory", Edited: true, UpdatedAt: time.Now()}
+		sent := SendTestMessage(t, conn, forged)
+		assert.Equal(t, "alice", sent.AuthorName)
+		assert.False(t, sent.Edited)
+		assert.True(t, sent.UpdatedAt.IsZero())
+		unlock := s.Chat.rlock()
+		stored := s.Chat.FindMessage(sent.ID)
+		assert.Empty(t, stored.AuthorName)
+		assert.False(t, stored.Edited)
+		unlock()
+	})
+
+	t.Run("Replying to an unknown message is rejected", func(t *testing.T) {
+		message := &Message{Content: "answer", AuthorID: client.AssignedId, ReplyTo: "unknown"}
+		if err := utils.WriteToUDPConn(conn, utils.AddMessageCommand, message); err != nil {
+			t.Error("could not write to UDP connection: ", err)
+		}
+		var packet ErrorPacket
+		UnpackTestData(t, ReadTestCommand(t, conn, utils.ErrorCommand), &packet)
+		assert.Equal(t, NotFoundCode, packet.Code)
+		assert.Contains(t, packet.Message, "doesnt exist")
+		unlock := s.Chat.rlock()
+		assert.Len(t, s.Chat.History, 3)
+		unlock()
+	})
+}
+
+func TestChat_Reactions(t *testing.T) {
+	s := StartTestServer(t)
+	address := s.Addr().String()
+
+	aliceConn := CreateTestConnection(t, address)
+	defer aliceConn.Close()
+	bobConn := CreateTestConnection(t, address)
+	defer bobConn.Close()
+
+	alice := AddTestClient(t, aliceConn, &LoginInput{Username: "alice"})
+	bob := AddTestClient(t, bobConn, &LoginInput{Username: "bob"})
+	message := SendTestMessage(t, aliceConn, &Message{Content: "ship it?", AuthorID: alice.AssignedId})
+
+	t.Run("Reacting to another client message is broadcast", func(t *testing.T) {
+		input := &ReactionInput{ClientID: bob.AssignedId, MessageID: message.ID, Emoji: ":thumbsup:"}
+		if err := utils.WriteToUDPConn(bobConn, utils.AddReactionCommand, input); err != nil {
+			t.Error("could not write to UDP connection: ", err)
+		}
+		var event ReactionEvent
+		UnpackTestData(t, ReadTestCommand(t, aliceConn, utils.AddReactionCommand), &event)
+		assert.Equal(t, ReactionEvent{MessageID: message.ID, Emoji: ":thumbsup:", Name: "bob"}, event)
+	})
+
+	t.Run("Reactions are persisted and sent with history by name", func(t *testing.T) {
+		history := FetchHistoryFromRedis(s.RedisClient, s.Chat.Logger)
+		if assert.Len(t, history, 1) {
+			assert.Equal(t, []string{bob.AssignedId}, history[0].Reactions[":thumbsup:"])
+		}
+
+		conn := CreateTestConnection(t, address)
+		defer conn.Close()
+		AddTestClient(t, conn, &LoginInput{Username: "carol"})
+		var historyLog HistoryLog
+		UnpackTestData(t, ReadTestCommand(t, conn, utils.AddHistoryCommand), &historyLog)
+		assert.Equal(t, []string{"bob"}, historyLog.Message.Reactions[":thumbsup:"])
+	})
+
+	t.Run("Removing a reaction is broadcast", func(t *testing.T) {
+		input := &ReactionInput{ClientID: bob.AssignedId, MessageID: message.ID, Emoji: ":thumbsup:"}
+		if err := utils.WriteToUDPConn(bobConn, utils.RemoveReactionCommand, input); err != nil {
+			t.Error("could not write to UDP connection: ", err)
+		}
+		var event ReactionEvent
+		UnpackTestData(t, ReadTestCommand(t, aliceConn, utils.RemoveReactionCommand), &event)
+		assert.Equal(t, "bob", event.Name)
+		assert.Empty(t, FetchHistoryFromRedis(s.RedisClient, s.Chat.Logger)[0].Reactions)
+	})
+
+	t.Run("Invalid emojis are rejected", func(t *testing.T) {
+		input := &ReactionInput{ClientID: bob.AssignedId, MessageID: message.ID, Emoji: "[red]x"}
+		if err := utils.WriteToUDPConn(bobConn, utils.AddReactionCommand, input); err != nil {
+			t.Error("could not write to UDP connection: ", err)
+		}
+		var packet ErrorPacket
+		UnpackTestData(t, ReadTestCommand(t, bobConn, utils.ErrorCommand), &packet)
+		assert.Equal(t, InvalidContentCode, packet.Code)
+		assert.Contains(t, packet.Message, "Invalid reaction")
+	})
+}
+
+func TestChat_Mentions(t *testing.T) {
+	s := StartTestServer(t)
+	address := s.Addr().String()
+
+	aliceConn := CreateTestConnection(t, address)
+	defer aliceConn.Close()
+	bobConn := CreateTestConnection(t, address)
+	defer bobConn.Close()
+
+	alice := AddTestClient(t, aliceConn, &LoginInput{Username: "alice"})
+	bob := AddTestClient(t, bobConn, &LoginInput{Username: "bob"})
+
+	t.Run("Mentioned clients only receive their own id", func(t *testing.T) {
+		sent := SendTestMessage(t, aliceConn, &Message{Content: "hey @Bob and @alice, @nobody", AuthorID: alice.AssignedId})
+		assert.Equal(t, []string{alice.AssignedId}, sent.MentionIDs)
+
+		var received Message
+		UnpackTestData(t, ReadTestCommand(t, bobConn, utils.AddMessageCommand), &received)
+		assert.Equal(t, []string{bob.AssignedId}, received.MentionIDs)
+		unlock := s.Chat.rlock()
+		assert.Len(t, s.Chat.History[0].MentionIDs, 2)
+		unlock()
+	})
+
+	t.Run("Requesting mentions returns messages mentioning the client", func(t *testing.T) {
+		DisconnectTestClient(t, bobConn, bob.AssignedId)
+		SendTestMessage(t, aliceConn, &Message{Content: "@bob are you there?", AuthorID: alice.AssignedId})
+		SendTestMessage(t, aliceConn, &Message{Content: "no mention", AuthorID: alice.AssignedId})
+		AddTestClient(t, bobConn, &LoginInput{Username: "bob", AssignedId: bob.AssignedId})
+
+		if err := utils.WriteToUDPConn(bobConn, utils.MentionsCommand, &MentionsInput{ClientID: bob.AssignedId}); err != nil {
+			t.Error("could not write to UDP connection: ", err)
+		}
+		var payload MentionsPayload
+		UnpackTestData(t, ReadTestCommand(t, bobConn, utils.MentionsCommand), &payload)
+		if assert.Len(t, payload.Messages, 2) {
+			assert.Equal(t, "@bob are you there?", payload.Messages[1].Content)
+			assert.Equal(t, "alice", payload.Messages[1].AuthorName)
+		}
+	})
+}
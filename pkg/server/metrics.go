@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	UnknownCommand = "unknown" // label of packets with an unrecognized command
+	HealthTimeout  = 2 * time.Second
+)
+
+// StorageLatencyBuckets are the upper bounds in seconds of the storage latency histogram.
+var StorageLatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+// Metrics counts server activity, exposed in the prometheus text format by ServeMetrics.
+type Metrics struct {
+	mu              sync.Mutex
+	packetsIn       map[string]uint64 // by command
+	packetsOut      map[string]uint64 // by command
+	handlerPanics   map[string]uint64 // by command
+	decodeErrors    uint64
+	messagesStored  uint64
+	retransmissions uint64
+	queued          int64 // packets waiting to be handed to a client sender
+	storage         *Histogram
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		packetsIn:     map[string]uint64{},
+		packetsOut:    map[string]uint64{},
+		handlerPanics: map[string]uint64{},
+		storage:       NewHistogram(StorageLatencyBuckets),
+	}
+}
+
+func (m *Metrics) PacketIn(command string) {
+	m.mu.Lock()
+	m.packetsIn[command] += 1
+	m.mu.Unlock()
+}
+
+// PacketOut counts a sent packet by the command it starts with.
+func (m *Metrics) PacketOut(msg []byte) {
+	m.mu.Lock()
+	m.packetsOut[packetCommand(msg)] += 1
+	m.mu.Unlock()
+}
+
+func (m *Metrics) HandlerPanic(command string) {
+	m.mu.Lock()
+	m.handlerPanics[command] += 1
+	m.mu.Unlock()
+}
+
+// PacketsIn returns the number of packets received for all commands.
+func (m *Metrics) PacketsIn() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	total := uint64(0)
+	for _, n := range m.packetsIn {
+		total += n
+	}
+	return total
+}
+
+func (m *Metrics) DecodeError() {
+	atomic.AddUint64(&m.decodeErrors, 1)
+}
+
+func (m *Metrics) MessageStored() {
+	atomic.AddUint64(&m.messagesStored, 1)
+}
+
+func (m *Metrics) Retransmitted(n int) {
+	atomic.AddUint64(&m.retransmissions, uint64(n))
+}
+
+// Queue adds delta to the number of packets waiting for a client sender.
+func (m *Metrics) Queue(delta int64) {
+	atomic.AddInt64(&m.queued, delta)
+}
+
+// Histogram counts observations in cumulative buckets.
+type Histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, buckets: make([]uint64, len(bounds))}
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.buckets[i] += 1
+		}
+	}
+	h.count += 1
+	h.sum += value
+}
+
+func (h *Histogram) write(w io.Writer, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, h.buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n", name, h.sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+type storageStartKey struct{}
+
+// storageHook observes the latency of every redis command and pipeline.
+type storageHook struct {
+	histogram *Histogram
+}
+
+func (h storageHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, storageStartKey{}, time.Now()), nil
+}
+
+func (h storageHook) AfterProcess(ctx context.Context, _ redis.Cmder) error {
+	h.observe(ctx)
+	return nil
+}
+
+func (h storageHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, storageStartKey{}, time.Now()), nil
+}
+
+func (h storageHook) AfterProcessPipeline(ctx context.Context, _ []redis.Cmder) error {
+	h.observe(ctx)
+	return nil
+}
+
+func (h storageHook) observe(ctx context.Context) {
+	if start, ok := ctx.Value(storageStartKey{}).(time.Time); ok {
+		h.histogram.Observe(time.Since(start).Seconds())
+	}
+}
+
+// WriteMetrics writes the server metrics in the prometheus text format.
+func (chat *Chat) WriteMetrics(w io.Writer) {
+	m := chat.Metrics
+	writeCounters := func(name string, help string, counts map[string]uint64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		commands := make([]string, 0, len(counts))
+		for command := range counts {
+			commands = append(commands, command)
+		}
+		sort.Strings(commands)
+		for _, command := range commands {
+			fmt.Fprintf(w, "%s{command=\"%s\"} %d\n", name, command, counts[command])
+		}
+	}
+	writeMetric := func(name string, kind string, help string, value interface{}) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+	}
+
+	m.mu.Lock()
+	writeCounters("udp_chat_packets_in_total", "Packets received by command.", m.packetsIn)
+	writeCounters("udp_chat_packets_out_total", "Packets sent by command.", m.packetsOut)
+	writeCounters("udp_chat_handler_panics_total", "Handlers recovered from a panic by command.", m.handlerPanics)
+	m.mu.Unlock()
+	writeMetric("udp_chat_decode_errors_total", "counter", "Packets that could not be decoded.", atomic.LoadUint64(&m.decodeErrors))
+	writeMetric("udp_chat_messages_stored_total", "counter", "Messages saved to storage.", atomic.LoadUint64(&m.messagesStored))
+	writeMetric("udp_chat_retransmissions_total", "counter", "History logs sent again after being lost.", atomic.LoadUint64(&m.retransmissions))
+	writeMetric("udp_chat_outbound_queue_depth", "gauge", "Packets waiting to be handed to a client sender.", atomic.LoadInt64(&m.queued))
+	chat.mu.RLock()
+	connected, history := chat.connected, len(chat.History)
+	chat.mu.RUnlock()
+	writeMetric("udp_chat_connected_clients", "gauge", "Clients currently online.", connected)
+	writeMetric("udp_chat_history_messages", "gauge", "Messages in the room history.", history)
+	fmt.Fprintf(w, "# HELP udp_chat_storage_latency_seconds Latency of storage commands.\n# TYPE udp_chat_storage_latency_seconds histogram\n")
+	m.storage.write(w, "udp_chat_storage_latency_seconds")
+}
+
+// CheckHealth reports whether the storage answers.
+func (chat *Chat) CheckHealth(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, HealthTimeout)
+	defer cancel()
+	if err := chat.RedisClient.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("storage is unavailable: %s", err)
+	}
+	return nil
+}
+
+// MetricsHandler serves /metrics and /healthz.
+func (chat *Chat) MetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		chat.WriteMetrics(w)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := chat.CheckHealth(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// ServeMetrics exposes the metrics and health check over HTTP.
+func (chat *Chat) ServeMetrics(address string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on metrics address: %s", err)
+	}
+	go func() {
+		if err := http.Serve(listener, chat.MetricsHandler()); err != nil {
+			chat.Logger.Info("metrics listener closed", "error", err)
+		}
+	}()
+	return listener, nil
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"github.com/go-redis/redis/v8"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChat_Metrics(t *testing.T) {
+	s := StartTestServer(t)
+	conn := CreateTestConnection(t, s.Addr().String())
+	defer conn.Close()
+	client := AddTestClient(t, conn, &LoginInput{Username: "alice"})
+	SendTestMessage(t, conn, &Message{Content: "hello", AuthorID: client.AssignedId})
+	if _, err := conn.Write([]byte("/bogus>{}")); err != nil {
+		t.Fatal("could not write to UDP connection: ", err)
+	}
+	time.Sleep(100 * time.Millisecond) // let the server handle the bogus packet
+
+	recorder := httptest.NewRecorder()
+	s.Chat.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	body := recorder.Body.String()
+	assert.Contains(t, body, `udp_chat_packets_in_total{command="`+utils.ConnectCommand+`"} 1`)
+	assert.Contains(t, body, `udp_chat_packets_in_total{command="`+UnknownCommand+`"} 1`)
+	assert.Contains(t, body, `udp_chat_packets_out_total{command="`+utils.InitialPayloadCommand+`"} 1`)
+	assert.Contains(t, body, "udp_chat_decode_errors_total 1")
+	assert.Contains(t, body, "udp_chat_messages_stored_total 1")
+	assert.Contains(t, body, "udp_chat_connected_clients 1")
+	assert.Contains(t, body, `udp_chat_storage_latency_seconds_bucket{le="+Inf"}`)
+}
+
+func TestChat_Health(t *testing.T) {
+	s := StartTestServer(t)
+
+	t.Run("Health check passes while storage answers", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		s.Chat.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("Health check fails when storage is unreachable", func(t *testing.T) {
+		chat := &Chat{RedisClient: redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"}), Metrics: NewMetrics()}
+		recorder := httptest.NewRecorder()
+		chat.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	})
+}
@@ -0,0 +1,283 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	KickAction    = "kick"
+	BanAction     = "ban"
+	UnbanAction   = "unban"
+	MuteAction    = "mute"
+	UnmuteAction  = "unmute"
+	PromoteAction = "promote"
+	DemoteAction  = "demote"
+)
+
+type ModerationInput struct {
+	IssuerID string `json:"issuer_id"`
+	Action   string `json:"action"`
+	Target   string `json:"target"`             // target client name
+	Duration string `json:"duration,omitempty"` // time.ParseDuration format, empty for indefinitely
+	Reason   string `json:"reason,omitempty"`
+}
+
+type Ban struct {
+	ClientID  string    `json:"client_id"`
+	IP        string    `json:"ip,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	IssuedBy  string    `json:"issued_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"` // zero value bans indefinitely
+}
+
+// Active reports whether ban is still in effect at the given time.
+func (b *Ban) Active(now time.Time) bool {
+	return b.ExpiresAt.IsZero() || now.Before(b.ExpiresAt)
+}
+
+// Notice builds the notice sent to the banned client.
+func (b *Ban) Notice() *Notice {
+	content := "You are banned from this chat"
+	if !b.ExpiresAt.IsZero() {
+		content = fmt.Sprintf("%s until %s", content, b.ExpiresAt.Format("Jan 2 15:04:05"))
+	}
+	return NewNotice(withReason(content, b.Reason))
+}
+
+func FetchBansFromRedis(redisClient *redis.Client, logger *slog.Logger) map[string]*Ban {
+	bans := map[string]*Ban{}
+	result, err := redisClient.HGetAll(context.Background(), utils.RedisBansKey).Result()
+	if err != nil && err != redis.Nil {
+		logger.Error("could not fetch redis bans", "error", err)
+		return bans
+	}
+	for clientID, str := range result {
+		var ban Ban
+		if err := json.Unmarshal([]byte(str), &ban); err != nil {
+			logger.Error("could not unmarshal ban", "client_id", clientID, "error", err)
+			continue
+		}
+		bans[clientID] = &ban
+	}
+	return bans
+}
+
+// FindActiveBan returns the active ban matching either the client id or ip, expired bans are dropped on the way.
+// Pass a nil ip for known clients so a ban never locks out everyone sharing the banned address.
+func (chat *Chat) FindActiveBan(clientID string, ip net.IP) *Ban {
+	now := time.Now()
+	for id, ban := range chat.Bans {
+		if !ban.Active(now) {
+			if err := chat.RemoveBan(id); err != nil {
+				chat.Logger.Error("failed to remove expired ban", "client_id", id, "error", err)
+			}
+			continue
+		}
+		if (clientID != "" && ban.ClientID == clientID) || (ip != nil && ban.IP == ip.String()) {
+			return ban
+		}
+	}
+	return nil
+}
+
+func (chat *Chat) SaveBan(ban *Ban) error {
+	bytes, err := json.Marshal(ban)
+	if err != nil {
+		return fmt.Errorf("could not marshal ban: %s", err)
+	}
+	if err := chat.RedisClient.HSet(context.Background(), utils.RedisBansKey, ban.ClientID, string(bytes)).Err(); err != nil {
+		return fmt.Errorf("could not save ban to redis: %s", err)
+	}
+	chat.Bans[ban.ClientID] = ban
+	return nil
+}
+
+func (chat *Chat) RemoveBan(clientID string) error {
+	if err := chat.RedisClient.HDel(context.Background(), utils.RedisBansKey, clientID).Err(); err != nil {
+		return fmt.Errorf("could not remove ban from redis: %s", err)
+	}
+	delete(chat.Bans, clientID)
+	return nil
+}
+
+// FindClientByName looks up a client by its case-insensitive name.
+func (chat *Chat) FindClientByName(name string) *Client {
+	for _, client := range chat.Clients {
+		if strings.EqualFold(client.Name, name) {
+			return client
+		}
+	}
+	return nil
+}
+
+func (chat *Chat) Moderate(data []byte, addr *net.UDPAddr) {
+	var input ModerationInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		chat.Metrics.DecodeError()
+		chat.Logger.Warn("failed to unmarshal moderation input", "addr", addr.String(), "error", err)
+		chat.SendError(addr, utils.ModerateCommand, InvalidRequestCode, "The request could not be decoded.")
+		return
+	}
+	unlock := chat.lock()
+	defer unlock()
+	issuer, ok := chat.Clients[input.IssuerID]
+	if !ok {
+		unlock()
+		chat.Logger.Warn("unrecognized client", "addr", addr.String(), "client_id", input.IssuerID)
+		chat.SendError(addr, utils.ModerateCommand, UnknownClientCode, "You are not connected, reconnect to continue.")
+		return
+	}
+	issuer.Touch()
+	var duration time.Duration
+	if input.Duration != "" {
+		var err error
+		duration, err = time.ParseDuration(input.Duration)
+		if err != nil || duration <= 0 {
+			unlock()
+			chat.SendError(addr, utils.ModerateCommand, InvalidRequestCode, fmt.Sprintf("Invalid duration \"%s\".", input.Duration))
+			return
+		}
+	}
+	notice, err := chat.ApplyModeration(issuer, input.Action, input.Target, duration, input.Reason)
+	unlock()
+	chat.AnnounceKicks()
+	if err != nil {
+		chat.SendError(addr, utils.ModerateCommand, ErrorCode(err), sentence(err))
+		return
+	}
+	chat.BroadcastNotice(notice)
+}
+
+// ApplyModeration runs a moderation action issued by issuer against the client named target and
+// returns the notice to be broadcast, rejections are RequestErrors. Callers must hold the chat lock.
+func (chat *Chat) ApplyModeration(issuer *Client, action, target string, duration time.Duration, reason string) (string, error) {
+	client := chat.FindClientByName(target)
+	if client == nil {
+		return "", rejection(NotFoundCode, "user \"%s\" doesnt exist", target)
+	}
+	switch action {
+	case PromoteAction, DemoteAction:
+		if issuer.Role != RoleOwner || client.ID == issuer.ID {
+			return "", rejection(ForbiddenCode, "only the owner can change roles")
+		}
+	default:
+		if !issuer.CanModerate(client) {
+			return "", rejection(ForbiddenCode, "you are not allowed to %s %s", action, client.Name)
+		}
+	}
+
+	var until time.Time
+	if duration > 0 {
+		until = time.Now().Add(duration)
+	}
+	switch action {
+	case KickAction:
+		chat.Kick(client, withReason(fmt.Sprintf("You were kicked by %s", issuer.Name), reason))
+		return withReason(fmt.Sprintf("%s was kicked by %s", client.Name, issuer.Name), reason), nil
+	case BanAction:
+		ban := &Ban{ClientID: client.ID, Reason: reason, IssuedBy: issuer.ID, CreatedAt: time.Now(), ExpiresAt: until}
+		if client.Address != nil {
+			ban.IP = client.Address.IP.String()
+		}
+		if err := chat.SaveBan(ban); err != nil {
+			chat.Logger.Error("failed to save ban", "client_id", client.ID, "error", err)
+			return "", fmt.Errorf("failed to ban %s", client.Name)
+		}
+		chat.Kick(client, ban.Notice().Content)
+		return withReason(fmt.Sprintf("%s was banned by %s%s", client.Name, issuer.Name, forDuration(duration)), reason), nil
+	case UnbanAction:
+		if _, ok := chat.Bans[client.ID]; !ok {
+			return "", rejection(NotFoundCode, "%s is not banned", client.Name)
+		}
+		if err := chat.RemoveBan(client.ID); err != nil {
+			chat.Logger.Error("failed to remove ban", "client_id", client.ID, "error", err)
+			return "", fmt.Errorf("failed to unban %s", client.Name)
+		}
+		return fmt.Sprintf("%s was unbanned by %s", client.Name, issuer.Name), nil
+	case MuteAction, UnmuteAction:
+		muted := action == MuteAction
+		if err := chat.UpdateClient(client, func(c *Client) {
+			c.Muted = muted
+			c.MutedUntil = until
+		}); err != nil {
+			chat.Logger.Error("failed to update mute", "client_id", client.ID, "error", err)
+			return "", fmt.Errorf("failed to %s %s", action, client.Name)
+		}
+		if muted {
+			return withReason(fmt.Sprintf("%s was muted by %s%s", client.Name, issuer.Name, forDuration(duration)), reason), nil
+		}
+		return fmt.Sprintf("%s was unmuted by %s", client.Name, issuer.Name), nil
+	case PromoteAction, DemoteAction:
+		role := RoleModerator
+		if action == DemoteAction {
+			role = RoleMember
+		}
+		if err := chat.UpdateClient(client, func(c *Client) { c.Role = role }); err != nil {
+			chat.Logger.Error("failed to update role", "client_id", client.ID, "error", err)
+			return "", fmt.Errorf("failed to %s %s", action, client.Name)
+		}
+		return fmt.Sprintf("%s is now a %s", client.Name, role), nil
+	}
+	return "", rejection(InvalidRequestCode, "unknown moderation action \"%s\"", action)
+}
+
+// kick is a client kicked under the chat lock, told once the lock is released.
+type kick struct {
+	client *Client
+	reason string
+}
+
+// Kick disconnects client, which is told why by AnnounceKicks. Callers must hold the chat lock.
+func (chat *Chat) Kick(client *Client, reason string) {
+	if !client.Online {
+		return
+	}
+	if err := chat.UpdateClient(client, func(c *Client) {
+		c.Online = false
+		c.LastSeen = time.Now()
+	}); err != nil {
+		chat.Logger.Error("failed to kick client", "client_id", client.ID, "error", err)
+		return
+	}
+	chat.connected -= 1
+	chat.kicked = append(chat.kicked, &kick{client: client, reason: reason})
+	chat.Logger.Info("client kicked", "addr", client.Address.String(), "client_id", client.ID)
+}
+
+// AnnounceKicks tells kicked clients why and lets the others know they left.
+func (chat *Chat) AnnounceKicks() {
+	unlock := chat.lock()
+	kicked := chat.kicked
+	chat.kicked = nil
+	unlock()
+	for _, k := range kicked {
+		if k.client.BroadcastChan != nil { // client never connected during this run
+			k.client.Send(utils.KickedCommand, NewNotice(k.reason))
+		}
+		chat.BroadcastPresence(LeavePresence, k.client, "")
+	}
+}
+
+func withReason(text, reason string) string {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return text + "."
+	}
+	return fmt.Sprintf("%s: %s", text, reason)
+}
+
+func forDuration(duration time.Duration) string {
+	if duration == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" for %s", duration)
+}
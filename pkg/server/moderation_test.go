@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestChat_Moderation(t *testing.T) {
+	s := StartTestServer(t)
+	address := s.Addr().String()
+
+	ownerConn := CreateTestConnection(t, address)
+	defer ownerConn.Close()
+	memberConn := CreateTestConnection(t, address)
+	defer memberConn.Close()
+
+	owner := AddTestClient(t, ownerConn, &LoginInput{Username: "owner"})
+	member := AddTestClient(t, memberConn, &LoginInput{Username: "member"})
+	assert.Equal(t, RoleOwner, owner.Role)
+	assert.Equal(t, RoleMember, member.Role)
+
+	t.Run("Members cannot moderate the owner", func(t *testing.T) {
+		input := &ModerationInput{IssuerID: member.AssignedId, Action: KickAction, Target: "owner"}
+		if err := utils.WriteToUDPConn(memberConn, utils.ModerateCommand, input); err != nil {
+			t.Error("could not write to UDP connection: ", err)
+		}
+		var packet ErrorPacket
+		UnpackTestData(t, ReadTestCommand(t, memberConn, utils.ErrorCommand), &packet)
+		assert.Equal(t, ForbiddenCode, packet.Code)
+		assert.Equal(t, "You are not allowed to kick owner.", packet.Message)
+	})
+
+	t.Run("Moderators can delete messages of other clients", func(t *testing.T) {
+		message := &Message{Content: "spam", AuthorID: member.AssignedId}
+		if err := utils.WriteToUDPConn(memberConn, utils.AddMessageCommand, message); err != nil {
+			t.Error("could not write to UDP connection: ", err)
+		}
+		var received Message
+		UnpackTestData(t, ReadTestCommand(t, ownerConn, utils.AddMessageCommand), &received)
+		assert.Empty(t, received.AuthorID)
+
+		received.AuthorID = owner.AssignedId
+		if err := utils.WriteToUDPConn(ownerConn, utils.DeleteMessageCommand, received); err != nil {
+			t.Error("could not write to UDP connection: ", err)
+		}
+		assert.Equal(t, received.ID, string(ReadTestCommand(t, memberConn, utils.DeleteMessageCommand)))
+		var notice Notice
+		UnpackTestData(t, ReadTestCommand(t, memberConn, utils.NoticeCommand), &notice)
+		assert.Contains(t, notice.Content, "removed by owner")
+	})
+
+	t.Run("Muted clients cannot send messages", func(t *testing.T) {
+		input := &ModerationInput{IssuerID: owner.AssignedId, Action: MuteAction, Target: "member", Duration: "1h"}
+		if err := utils.WriteToUDPConn(ownerConn, utils.ModerateCommand, input); err != nil {
+			t.Error("could not write to UDP connection: ", err)
+		}
+		var notice Notice
+		UnpackTestData(t, ReadTestCommand(t, memberConn, utils.NoticeCommand), &notice)
+		assert.Contains(t, notice.Content, "member was muted by owner for 1h0m0s")
+
+		message := &Message{Content: "hello", AuthorID: member.AssignedId}
+		if err := utils.WriteToUDPConn(memberConn, utils.AddMessageCommand, message); err != nil {
+			t.Error("could not write to UDP connection: ", err)
+		}
+		var packet ErrorPacket
+		UnpackTestData(t, ReadTestCommand(t, memberConn, utils.ErrorCommand), &packet)
+		assert.Equal(t, ForbiddenCode, packet.Code)
+		assert.Contains(t, packet.Message, "You are muted")
+	})
+
+	t.Run("Banned clients are kicked and cannot reconnect", func(t *testing.T) {
+		input := &ModerationInput{IssuerID: owner.AssignedId, Action: BanAction, Target: "member", Reason: "spam"}
+		if err := utils.WriteToUDPConn(ownerConn, utils.ModerateCommand, input); err != nil {
+			t.Error("could not write to UDP connection: ", err)
+		}
+		var notice Notice
+		UnpackTestData(t, ReadTestCommand(t, memberConn, utils.KickedCommand), &notice)
+		assert.Contains(t, notice.Content, "banned")
+
+		loginInput := &LoginInput{Username: "member", AssignedId: member.AssignedId}
+		if err := utils.WriteToUDPConn(memberConn, utils.ConnectCommand, loginInput); err != nil {
+			t.Error("could not write to UDP connection: ", err)
+		}
+		UnpackTestData(t, ReadTestCommand(t, memberConn, utils.KickedCommand), &notice)
+		assert.Contains(t, notice.Content, "spam")
+
+		bansCount, err := s.RedisClient.HLen(context.Background(), utils.RedisBansKey).Result()
+		if err != nil {
+			t.Error("failed to fetch bans from redis: ", err)
+		}
+		assert.Equal(t, int64(1), bansCount)
+		assert.Contains(t, FetchBansFromRedis(s.RedisClient, s.Chat.Logger), member.AssignedId)
+	})
+
+	t.Run("Banning a client keeps out new identities from its address only", func(t *testing.T) {
+		newConn := CreateTestConnection(t, address)
+		defer newConn.Close()
+		if err := utils.WriteToUDPConn(newConn, utils.ConnectCommand, &LoginInput{Username: "newcomer"}); err != nil {
+			t.Error("could not write to UDP connection: ", err)
+		}
+		var notice Notice
+		UnpackTestData(t, ReadTestCommand(t, newConn, utils.KickedCommand), &notice)
+		assert.Contains(t, notice.Content, "banned")
+
+		DisconnectTestClient(t, ownerConn, owner.AssignedId)
+		reconnected := AddTestClient(t, ownerConn, &LoginInput{Username: "owner", AssignedId: owner.AssignedId})
+		assert.Equal(t, owner.AssignedId, reconnected.AssignedId)
+		assert.Equal(t, RoleOwner, reconnected.Role)
+	})
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"net"
+	"regexp"
+	"strconv"
+)
+
+const (
+	MinNicknameLength = 2
+	MaxNicknameLength = 20
+	DefaultNickname   = "guest"
+)
+
+var nicknameReg = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+var invalidNicknameCharsReg = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+type NickInput struct {
+	ClientID string `json:"client_id"`
+	Name     string `json:"name"`
+}
+
+// ValidateNickname checks nickname length and allowed characters.
+func ValidateNickname(name string) error {
+	if len(name) < MinNicknameLength || len(name) > MaxNicknameLength {
+		return fmt.Errorf("nickname must be between %d and %d characters", MinNicknameLength, MaxNicknameLength)
+	}
+	if !nicknameReg.MatchString(name) {
+		return fmt.Errorf("nickname can only contain letters, digits, \"_\" and \"-\"")
+	}
+	return nil
+}
+
+// CheckNickname validates name and makes sure no other client owns it regardless of case.
+// Callers must hold the chat lock.
+func (chat *Chat) CheckNickname(name string, clientID string) error {
+	if err := ValidateNickname(name); err != nil {
+		return &RequestError{Code: InvalidContentCode, Err: err}
+	}
+	if owner := chat.FindClientByName(name); owner != nil && owner.ID != clientID {
+		return rejection(ConflictCode, "nickname \"%s\" is already taken", name)
+	}
+	return nil
+}
+
+// UniqueNickname derives an available nickname from name. Callers must hold the chat lock.
+func (chat *Chat) UniqueNickname(name string) string {
+	if chat.CheckNickname(name, "") == nil {
+		return name
+	}
+	base := invalidNicknameCharsReg.ReplaceAllString(name, "")
+	if len(base) < MinNicknameLength {
+		base = DefaultNickname
+	}
+	if len(base) > MaxNicknameLength-4 { // leave room for the suffix
+		base = base[:MaxNicknameLength-4]
+	}
+	if chat.CheckNickname(base, "") == nil {
+		return base
+	}
+	for i := 2; ; i++ {
+		candidate := base + strconv.Itoa(i)
+		if chat.CheckNickname(candidate, "") == nil {
+			return candidate
+		}
+	}
+}
+
+func (chat *Chat) ChangeNickname(data []byte, addr *net.UDPAddr) {
+	var input NickInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		chat.Metrics.DecodeError()
+		chat.Logger.Warn("failed to unmarshal nick input", "addr", addr.String(), "error", err)
+		chat.SendError(addr, utils.NickCommand, InvalidRequestCode, "The request could not be decoded.")
+		return
+	}
+	unlock := chat.lock()
+	defer unlock()
+	client, ok := chat.Clients[input.ClientID]
+	if !ok || !client.Online {
+		unlock()
+		chat.Logger.Warn("unrecognized client", "addr", addr.String(), "client_id", input.ClientID)
+		chat.SendError(addr, utils.NickCommand, UnknownClientCode, "You are not connected, reconnect to continue.")
+		return
+	}
+	client.Touch()
+	oldName := client.Name
+	if oldName == input.Name {
+		unlock()
+		return
+	}
+	if err := chat.CheckNickname(input.Name, client.ID); err != nil {
+		unlock()
+		chat.SendError(addr, utils.NickCommand, ErrorCode(err), fmt.Sprintf("Could not change nickname: %s.", err))
+		return
+	}
+	if err := chat.UpdateClient(client, func(c *Client) { c.Name = input.Name }); err != nil {
+		unlock()
+		chat.Logger.Error("failed to rename client", "addr", addr.String(), "client_id", client.ID, "error", err)
+		chat.SendError(addr, utils.NickCommand, InternalErrorCode, "The server failed to handle the request, try again.")
+		return
+	}
+	unlock()
+
+	chat.Logger.Info("client renamed", "addr", addr.String(), "client_id", client.ID, "old_name", oldName, "name", input.Name)
+	chat.BroadcastPresence(RenamePresence, client, oldName)
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidateNickname(t *testing.T) {
+	assert.NoError(t, ValidateNickname("alice_01"))
+	assert.NoError(t, ValidateNickname("bob-b"))
+	assert.Error(t, ValidateNickname("a"))
+	assert.Error(t, ValidateNickname("a_very_long_nickname_over_limit"))
+	assert.Error(t, ValidateNickname("alice bob"))
+	assert.Error(t, ValidateNickname("[red]alice"))
+}
+
+func TestChat_Nicknames(t *testing.T) {
+	s := StartTestServer(t)
+	address := s.Addr().String()
+
+	aliceConn := CreateTestConnection(t, address)
+	defer aliceConn.Close()
+	otherConn := CreateTestConnection(t, address)
+	defer otherConn.Close()
+
+	alice := AddTestClient(t, aliceConn, &LoginInput{Username: "alice"})
+	other := AddTestClient(t, otherConn, &LoginInput{Username: "ALICE"})
+
+	t.Run("Joining with a taken nickname assigns a unique one", func(t *testing.T) {
+		assert.Equal(t, "alice", alice.Username)
+		assert.Equal(t, "ALICE2", other.Username)
+		var notice Notice
+		UnpackTestData(t, ReadTestCommand(t, otherConn, utils.NoticeCommand), &notice)
+		assert.Contains(t, notice.Content, "unavailable")
+	})
+
+	t.Run("Renaming to a taken nickname is rejected", func(t *testing.T) {
+		if err := utils.WriteToUDPConn(otherConn, utils.NickCommand, &NickInput{ClientID: other.AssignedId, Name: "Alice"}); err != nil {
+			t.Error("could not write to UDP connection: ", err)
+		}
+		var packet ErrorPacket
+		UnpackTestData(t, ReadTestCommand(t, otherConn, utils.ErrorCommand), &packet)
+		assert.Equal(t, ConflictCode, packet.Code)
+		assert.Contains(t, packet.Message, "already taken")
+	})
+
+	t.Run("Renaming is broadcast as a rename event", func(t *testing.T) {
+		if err := utils.WriteToUDPConn(otherConn, utils.NickCommand, &NickInput{ClientID: other.AssignedId, Name: "bob"}); err != nil {
+			t.Error("could not write to UDP connection: ", err)
+		}
+		var event PresenceEvent
+		for event.Type != RenamePresence {
+			UnpackTestData(t, ReadTestCommand(t, aliceConn, utils.PresenceCommand), &event)
+		}
+		assert.Equal(t, "ALICE2", event.OldName)
+		assert.Equal(t, "bob", event.Name)
+	})
+
+	t.Run("Reconnecting with an unknown assigned id registers a new client", func(t *testing.T) {
+		conn := CreateTestConnection(t, address)
+		defer conn.Close()
+		payload := AddTestClient(t, conn, &LoginInput{Username: "carol", AssignedId: "unknown"})
+		assert.NotEqual(t, "unknown", payload.AssignedId)
+		assert.Equal(t, "carol", payload.Username)
+	})
+}
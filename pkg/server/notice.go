@@ -0,0 +1,28 @@
+package server
+
+import (
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"time"
+)
+
+type Notice struct {
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewNotice(content string) *Notice {
+	return &Notice{Content: content, CreatedAt: time.Now()}
+}
+
+// SendNotice sends a system notice to a single client.
+func (chat *Chat) SendNotice(client *Client, content string) {
+	if client.BroadcastChan == nil { // client never connected during this run
+		return
+	}
+	client.Send(utils.NoticeCommand, NewNotice(content))
+}
+
+// BroadcastNotice sends a system notice to all online clients.
+func (chat *Chat) BroadcastNotice(content string) {
+	chat.Broadcast(utils.NoticeCommand, NewNotice(content))
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"net"
+	"sort"
+	"time"
+)
+
+const (
+	JoinPresence   = "join"
+	LeavePresence  = "leave"
+	RenamePresence = "rename"
+	AwayPresence   = "away"
+	BackPresence   = "back"
+
+	OnlineStatus = "online"
+	AwayStatus   = "away"
+)
+
+type PresenceEvent struct {
+	Type      string    `json:"type"`
+	Name      string    `json:"name"`
+	OldName   string    `json:"old_name,omitempty"` // rename only
+	Role      string    `json:"role,omitempty"`
+	Message   string    `json:"message,omitempty"` // away message
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RosterEntry struct {
+	Name        string `json:"name"`
+	Role        string `json:"role"`
+	Status      string `json:"status"`
+	AwayMessage string `json:"away_message,omitempty"`
+}
+
+type AwayInput struct {
+	ClientID string `json:"client_id"`
+	Away     bool   `json:"away"`
+	Message  string `json:"message,omitempty"`
+}
+
+type WhoisInput struct {
+	ClientID string `json:"client_id"`
+	Name     string `json:"name"`
+}
+
+type WhoisInfo struct {
+	Name        string    `json:"name"`
+	Role        string    `json:"role"`
+	Online      bool      `json:"online"`
+	Status      string    `json:"status,omitempty"`
+	AwayMessage string    `json:"away_message,omitempty"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// Status returns the presence status of an online client.
+func (c *Client) Status() string {
+	if c.Away {
+		return AwayStatus
+	}
+	return OnlineStatus
+}
+
+// Roster lists online clients sorted by name. Callers must hold the chat lock.
+func (chat *Chat) Roster() []*RosterEntry {
+	roster := make([]*RosterEntry, 0, chat.connected)
+	for _, client := range chat.Clients {
+		if !client.Online {
+			continue
+		}
+		roster = append(roster, &RosterEntry{
+			Name:        client.Name,
+			Role:        client.Role,
+			Status:      client.Status(),
+			AwayMessage: client.AwayMessage,
+		})
+	}
+	sort.Slice(roster, func(i, j int) bool { return roster[i].Name < roster[j].Name })
+	return roster
+}
+
+// BroadcastPresence lets all online clients know about a presence change of client.
+func (chat *Chat) BroadcastPresence(eventType string, client *Client, oldName string) {
+	event := &PresenceEvent{
+		Type:      eventType,
+		Name:      client.Name,
+		OldName:   oldName,
+		Role:      client.Role,
+		Message:   client.AwayMessage,
+		CreatedAt: time.Now(),
+	}
+	chat.Broadcast(utils.PresenceCommand, event)
+}
+
+func (chat *Chat) SetAway(data []byte, addr *net.UDPAddr) {
+	var input AwayInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		chat.Metrics.DecodeError()
+		chat.Logger.Warn("failed to unmarshal away input", "addr", addr.String(), "error", err)
+		chat.SendError(addr, utils.AwayCommand, InvalidRequestCode, "The request could not be decoded.")
+		return
+	}
+	unlock := chat.lock()
+	defer unlock()
+	client, ok := chat.Clients[input.ClientID]
+	if !ok || !client.Online {
+		unlock()
+		chat.Logger.Warn("unrecognized client", "addr", addr.String(), "client_id", input.ClientID)
+		chat.SendError(addr, utils.AwayCommand, UnknownClientCode, "You are not connected, reconnect to continue.")
+		return
+	}
+	client.Touch()
+	if client.Away == input.Away && client.AwayMessage == input.Message {
+		unlock()
+		return
+	}
+	client.Away = input.Away
+	client.AwayMessage = ""
+	if input.Away {
+		client.AwayMessage = input.Message
+	}
+	unlock()
+
+	eventType := BackPresence
+	if input.Away {
+		eventType = AwayPresence
+	}
+	chat.BroadcastPresence(eventType, client, "")
+}
+
+func (chat *Chat) Whois(data []byte, addr *net.UDPAddr) {
+	var input WhoisInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		chat.Metrics.DecodeError()
+		chat.Logger.Warn("failed to unmarshal whois input", "addr", addr.String(), "error", err)
+		chat.SendError(addr, utils.WhoisCommand, InvalidRequestCode, "The request could not be decoded.")
+		return
+	}
+	unlock := chat.rlock()
+	defer unlock()
+	requester, ok := chat.Clients[input.ClientID]
+	if !ok {
+		unlock()
+		chat.Logger.Warn("unrecognized client", "addr", addr.String(), "client_id", input.ClientID)
+		chat.SendError(addr, utils.WhoisCommand, UnknownClientCode, "You are not connected, reconnect to continue.")
+		return
+	}
+	target := chat.FindClientByName(input.Name)
+	if target == nil {
+		unlock()
+		chat.SendError(addr, utils.WhoisCommand, NotFoundCode, fmt.Sprintf("User \"%s\" doesnt exist.", input.Name))
+		return
+	}
+	info := &WhoisInfo{
+		Name:     target.Name,
+		Role:     target.Role,
+		Online:   target.Online,
+		LastSeen: target.LastSeen,
+	}
+	if target.Online {
+		info.Status = target.Status()
+		info.AwayMessage = target.AwayMessage
+	}
+	unlock()
+	requester.Send(utils.WhoisCommand, info)
+}
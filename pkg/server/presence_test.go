@@ -0,0 +1,64 @@
+package server
+
+import (
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestChat_Presence(t *testing.T) {
+	s := StartTestServer(t)
+	address := s.Addr().String()
+
+	aliceConn := CreateTestConnection(t, address)
+	defer aliceConn.Close()
+	bobConn := CreateTestConnection(t, address)
+	defer bobConn.Close()
+
+	alice := AddTestClient(t, aliceConn, &LoginInput{Username: "alice"})
+	bob := AddTestClient(t, bobConn, &LoginInput{Username: "bob"})
+
+	t.Run("Joining client receives the roster of online clients", func(t *testing.T) {
+		if assert.Len(t, bob.Roster, 2) {
+			assert.Equal(t, "alice", bob.Roster[0].Name)
+			assert.Equal(t, "bob", bob.Roster[1].Name)
+		}
+	})
+
+	t.Run("Online clients receive join events", func(t *testing.T) {
+		var event PresenceEvent
+		for event.Name != "bob" {
+			UnpackTestData(t, ReadTestCommand(t, aliceConn, utils.PresenceCommand), &event)
+		}
+		assert.Equal(t, JoinPresence, event.Type)
+	})
+
+	t.Run("Going away is broadcast with the away message", func(t *testing.T) {
+		if err := utils.WriteToUDPConn(bobConn, utils.AwayCommand, &AwayInput{ClientID: bob.AssignedId, Away: true, Message: "lunch"}); err != nil {
+			t.Error("could not write to UDP connection: ", err)
+		}
+		var event PresenceEvent
+		UnpackTestData(t, ReadTestCommand(t, aliceConn, utils.PresenceCommand), &event)
+		assert.Equal(t, AwayPresence, event.Type)
+		assert.Equal(t, "lunch", event.Message)
+	})
+
+	t.Run("Whois returns user status", func(t *testing.T) {
+		if err := utils.WriteToUDPConn(aliceConn, utils.WhoisCommand, &WhoisInput{ClientID: alice.AssignedId, Name: "bob"}); err != nil {
+			t.Error("could not write to UDP connection: ", err)
+		}
+		var info WhoisInfo
+		UnpackTestData(t, ReadTestCommand(t, aliceConn, utils.WhoisCommand), &info)
+		assert.True(t, info.Online)
+		assert.Equal(t, AwayStatus, info.Status)
+		assert.Equal(t, RoleMember, info.Role)
+	})
+
+	t.Run("Disconnecting is broadcast as a leave event", func(t *testing.T) {
+		DisconnectTestClient(t, bobConn, bob.AssignedId)
+		var event PresenceEvent
+		UnpackTestData(t, ReadTestCommand(t, aliceConn, utils.PresenceCommand), &event)
+		assert.Equal(t, LeavePresence, event.Type)
+		assert.Equal(t, "bob", event.Name)
+	})
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"net"
+	"regexp"
+	"time"
+)
+
+// emojiReg matches emoji short codes such as ":thumbsup:".
+var emojiReg = regexp.MustCompile(`^:[a-z0-9_+-]{1,32}:$`)
+
+type ReactionInput struct {
+	ClientID  string `json:"client_id"`
+	MessageID string `json:"message_id"`
+	Emoji     string `json:"emoji"`
+}
+
+type ReactionEvent struct {
+	MessageID string `json:"message_id"`
+	Emoji     string `json:"emoji"`
+	Name      string `json:"name"`
+}
+
+// React adds or removes a reaction of a client to a message and broadcasts the change.
+func (chat *Chat) React(data []byte, addr *net.UDPAddr, add bool) {
+	command := utils.AddReactionCommand
+	if !add {
+		command = utils.RemoveReactionCommand
+	}
+	var input ReactionInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		chat.Metrics.DecodeError()
+		chat.Logger.Warn("failed to unmarshal reaction input", "addr", addr.String(), "error", err)
+		chat.SendError(addr, command, InvalidRequestCode, "The request could not be decoded.")
+		return
+	}
+	unlock := chat.lock()
+	defer unlock()
+	client, ok := chat.Clients[input.ClientID]
+	if !ok || !client.Online {
+		unlock()
+		chat.Logger.Warn("unrecognized client", "addr", addr.String(), "client_id", input.ClientID)
+		chat.SendError(addr, command, UnknownClientCode, "You are not connected, reconnect to continue.")
+		return
+	}
+	client.Touch()
+	if !emojiReg.MatchString(input.Emoji) {
+		unlock()
+		chat.SendError(addr, command, InvalidContentCode, fmt.Sprintf("Invalid reaction \"%s\", use short codes like :thumbsup:.", input.Emoji))
+		return
+	}
+	message := chat.FindMessage(input.MessageID)
+	if message == nil {
+		unlock()
+		chat.SendError(addr, command, NotFoundCode, "The message you reacted to doesnt exist anymore.")
+		return
+	}
+	reactors := message.Reactions[input.Emoji]
+	index := -1
+	for i, id := range reactors {
+		if id == client.ID {
+			index = i
+		}
+	}
+	if (index != -1) == add { // nothing changes
+		unlock()
+		return
+	}
+	if err := chat.UpdateMessage(message, func(m *Message) {
+		reactions := make(map[string][]string, len(m.Reactions)+1) // copy so sent snapshots are not mutated
+		for emoji, ids := range m.Reactions {
+			reactions[emoji] = ids
+		}
+		if add {
+			reactions[input.Emoji] = append(append([]string{}, reactors...), client.ID)
+		} else {
+			reactions[input.Emoji] = append(append([]string{}, reactors[:index]...), reactors[index+1:]...)
+			if len(reactions[input.Emoji]) == 0 {
+				delete(reactions, input.Emoji)
+			}
+		}
+		m.Reactions = reactions
+	}); err != nil {
+		unlock()
+		chat.Logger.Error("failed to update reactions", "addr", addr.String(), "client_id", client.ID, "message_id", message.ID, "error", err)
+		chat.SendError(addr, command, InternalErrorCode, "The server failed to handle the request, try again.")
+		return
+	}
+	event := &ReactionEvent{MessageID: message.ID, Emoji: input.Emoji, Name: client.Name}
+	unlock()
+
+	chat.Broadcast(command, event)
+}
+
+// UpdateMessage applies update to a message from history and replaces its redis entry.
+// Callers must hold the chat lock.
+func (chat *Chat) UpdateMessage(message *Message, update func(m *Message)) error {
+	updated := *message
+	update(&updated)
+	updated.UpdatedAt = time.Now()
+	bytes, err := json.Marshal(&updated)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %s", err)
+	}
+	if err := chat.RedisClient.HSet(context.Background(), utils.RedisMessagesKey, message.ID, string(bytes)).Err(); err != nil {
+		return fmt.Errorf("could not update message \"%s\" on redis: %s", message.ID, err)
+	}
+	if updated.Content != message.Content { // keep edited content searchable
+		chat.Index.Remove(message)
+		chat.Index.Add(&updated)
+	}
+	*message = updated
+	return nil
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"log/slog"
+	"net"
+	"time"
+)
+
+const (
+	UnreadLimit     = 200                  // most unread messages delivered on reconnect
+	HistoryPageSize = 50                   // history logs sent before pausing
+	HistoryPageWait = 5 * time.Millisecond // pause between pages so clients are not flooded
+)
+
+type ReadInput struct {
+	ClientID  string `json:"client_id"`
+	MessageID string `json:"message_id"`
+}
+
+func FetchReadCursorsFromRedis(redisClient *redis.Client, logger *slog.Logger) map[string]string {
+	cursors, err := redisClient.HGetAll(context.Background(), utils.RedisReadCursorsKey).Result()
+	if err != nil && err != redis.Nil {
+		logger.Error("could not fetch redis read cursors", "error", err)
+		return map[string]string{}
+	}
+	return cursors
+}
+
+// MessageIndex returns the position of a message in history, -1 if it is not found.
+// Callers must hold the chat lock.
+func (chat *Chat) MessageIndex(id string) int {
+	for i := len(chat.History) - 1; i >= 0; i-- {
+		if chat.History[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// SaveReadCursor stores the last message read by a client. Callers must hold the chat lock.
+func (chat *Chat) SaveReadCursor(clientID string, messageID string) error {
+	if err := chat.RedisClient.HSet(context.Background(), utils.RedisReadCursorsKey, clientID, messageID).Err(); err != nil {
+		return fmt.Errorf("could not save read cursor to redis: %s", err)
+	}
+	chat.ReadCursors[clientID] = messageID
+	return nil
+}
+
+// UnreadIndex returns the position of the first message client hasn't read, clients without a cursor
+// have read everything. Callers must hold the chat lock.
+func (chat *Chat) UnreadIndex(clientID string) int {
+	cursor, ok := chat.ReadCursors[clientID]
+	if !ok {
+		return len(chat.History)
+	}
+	// a cursor missing from history was removed with older messages so everything is unread
+	return chat.MessageIndex(cursor) + 1
+}
+
+// MarkRead moves the read cursor of a client forward.
+func (chat *Chat) MarkRead(data []byte, addr *net.UDPAddr) {
+	var input ReadInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		chat.Metrics.DecodeError()
+		chat.Logger.Warn("failed to unmarshal read input", "addr", addr.String(), "error", err)
+		chat.SendError(addr, utils.MarkReadCommand, InvalidRequestCode, "The request could not be decoded.")
+		return
+	}
+	unlock := chat.lock()
+	defer unlock()
+	client, ok := chat.Clients[input.ClientID]
+	if !ok || !client.Online {
+		unlock()
+		chat.Logger.Warn("unrecognized client", "addr", addr.String(), "client_id", input.ClientID)
+		chat.SendError(addr, utils.MarkReadCommand, UnknownClientCode, "You are not connected, reconnect to continue.")
+		return
+	}
+	client.Touch()
+	index := chat.MessageIndex(input.MessageID)
+	if index == -1 {
+		unlock()
+		chat.Logger.Warn("message to mark as read does not exist", "addr", addr.String(), "client_id", client.ID, "message_id", input.MessageID)
+		chat.SendError(addr, utils.MarkReadCommand, NotFoundCode, "The message to mark as read doesnt exist anymore.")
+		return
+	}
+	if index < chat.UnreadIndex(client.ID)-1 { // older than the current cursor
+		return
+	}
+	if err := chat.SaveReadCursor(client.ID, input.MessageID); err != nil {
+		unlock()
+		chat.Logger.Error("failed to save read cursor", "addr", addr.String(), "client_id", client.ID, "error", err)
+		chat.SendError(addr, utils.MarkReadCommand, InternalErrorCode, "The server failed to handle the request, try again.")
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestChat_OfflineDelivery(t *testing.T) {
+	s := StartTestServer(t)
+	address := s.Addr().String()
+
+	aliceConn := CreateTestConnection(t, address)
+	defer aliceConn.Close()
+	bobConn := CreateTestConnection(t, address)
+	defer bobConn.Close()
+
+	alice := AddTestClient(t, aliceConn, &LoginInput{Username: "alice"})
+	bob := AddTestClient(t, bobConn, &LoginInput{Username: "bob"})
+
+	read := SendTestMessage(t, aliceConn, &Message{Content: "read", AuthorID: alice.AssignedId})
+	if err := utils.WriteToUDPConn(bobConn, utils.MarkReadCommand, &ReadInput{ClientID: bob.AssignedId, MessageID: read.ID}); err != nil {
+		t.Error("could not write to UDP connection: ", err)
+	}
+	time.Sleep(100 * time.Millisecond) // wait for the read cursor to be saved
+	DisconnectTestClient(t, bobConn, bob.AssignedId)
+
+	for _, content := range []string{"first unread", "second unread", "third unread"} {
+		SendTestMessage(t, aliceConn, &Message{Content: content, AuthorID: alice.AssignedId})
+	}
+
+	t.Run("Reconnecting delivers every message since the read cursor", func(t *testing.T) {
+		payload := AddTestClient(t, bobConn, &LoginInput{Username: "bob", AssignedId: bob.AssignedId})
+		assert.Equal(t, 3, payload.UnreadCount)
+		assert.Equal(t, 4, payload.HistoryLength)
+
+		var historyLog HistoryLog
+		for historyLog.Order != payload.HistoryLength-payload.UnreadCount {
+			UnpackTestData(t, ReadTestCommand(t, bobConn, utils.AddHistoryCommand), &historyLog)
+		}
+		assert.Equal(t, "first unread", historyLog.Message.Content)
+	})
+
+	t.Run("Read cursors never move backwards", func(t *testing.T) {
+		unlock := s.Chat.rlock()
+		last := s.Chat.History[len(s.Chat.History)-1]
+		unlock()
+		for _, id := range []string{last.ID, read.ID} {
+			if err := utils.WriteToUDPConn(bobConn, utils.MarkReadCommand, &ReadInput{ClientID: bob.AssignedId, MessageID: id}); err != nil {
+				t.Error("could not write to UDP connection: ", err)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		cursors := FetchReadCursorsFromRedis(s.RedisClient, s.Chat.Logger)
+		assert.Equal(t, last.ID, cursors[bob.AssignedId])
+	})
+}
@@ -0,0 +1,318 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultReplayTimeout bounds the wait for a replayed packet to be handled and answered.
+const DefaultReplayTimeout = 5 * time.Second
+
+// replayIDReg matches ids generated by the server, which differ between the capture and the replay.
+var replayIDReg = regexp.MustCompile(`"(?:id|assigned_id|message_id|client_id|author_id|reply_to)":"([0-9a-v]{20})"`)
+
+type ReplayResult struct {
+	Packets   int      // inbound packets replayed
+	State     []string // chat state after the replay
+	StateDiff []string // lines of the captured state missing (-) or added (+) by the replay
+	Diff      []string // outbound commands of the capture missing (-) or added (+) by the replay, by peer
+}
+
+// Diverges reports whether the replay ended in another state or answered differently than the capture.
+func (r *ReplayResult) Diverges() bool {
+	return len(r.StateDiff) != 0 || len(r.Diff) != 0
+}
+
+// activity counts the work a chat has in progress: packets being handled and packets handed to client
+// senders but not written yet. Handlers run concurrently, a replay waits for it to settle before sending
+// the next packet so every client receives its packets in the order of the capture.
+type activity struct {
+	read    int64 // packets read
+	written int64 // packets written
+	busy    int64
+}
+
+// received counts a packet read, its handler is in progress until done is called.
+func (a *activity) received() {
+	atomic.AddInt64(&a.busy, 1)
+	atomic.AddInt64(&a.read, 1)
+}
+
+func (a *activity) wrote() {
+	atomic.AddInt64(&a.written, 1)
+}
+
+func (a *activity) start() {
+	atomic.AddInt64(&a.busy, 1)
+}
+
+func (a *activity) done() {
+	atomic.AddInt64(&a.busy, -1)
+}
+
+// settled reports whether read packets were all handled and their answers written.
+func (a *activity) settled(read int64) bool {
+	return atomic.LoadInt64(&a.read) >= read && atomic.LoadInt64(&a.busy) == 0
+}
+
+// replayPeer sends the packets of one captured client from its own socket.
+type replayPeer struct {
+	name     string
+	conn     *net.UDPConn
+	expected map[string][][]byte // captured outbound packets by command, in order
+	captured []string            // captured outbound commands
+	mu       sync.Mutex
+	replayed []string // outbound commands received during the replay
+	received int64    // outbound packets received during the replay
+}
+
+// Replay sends the inbound packets of a server capture to s from one socket per captured peer,
+// translating ids generated by the captured server to the ones generated by s. Packets are sent one
+// at a time, each once the previous one was handled and its answers received, and the resulting state
+// is compared to the last one recorded by the capture.
+func Replay(s *Server, records []*utils.CaptureRecord, timeout time.Duration) (*ReplayResult, error) {
+	if s.Chat == nil {
+		if err := s.Listen(); err != nil {
+			return nil, err
+		}
+		go s.Run()
+	}
+	ids := &sync.Map{} // captured id to replayed id
+	peers := map[string]*replayPeer{}
+	order := make([]*replayPeer, 0)
+	peer := func(address string) (*replayPeer, error) {
+		if p, ok := peers[address]; ok {
+			return p, nil
+		}
+		conn, err := utils.GetUDPConnection(s.Addr().String())
+		if err != nil {
+			return nil, fmt.Errorf("could not create replay connection: %s", err)
+		}
+		p := &replayPeer{name: address, conn: conn, expected: map[string][][]byte{}}
+		peers[address] = p
+		order = append(order, p)
+		return p, nil
+	}
+	var captured []string
+	for _, record := range records {
+		if record.Direction == utils.StateRecord {
+			captured = record.State
+			continue
+		}
+		p, err := peer(record.Peer)
+		if err != nil {
+			return nil, err
+		}
+		if record.Direction == utils.OutboundPacket {
+			command := packetCommand(record.Packet)
+			p.expected[command] = append(p.expected[command], record.Packet)
+			p.captured = append(p.captured, command)
+		}
+	}
+	var wg sync.WaitGroup
+	for _, p := range order {
+		wg.Add(1)
+		go func(p *replayPeer) {
+			defer wg.Done()
+			p.Listen(ids)
+		}(p)
+	}
+
+	result := &ReplayResult{}
+	read, written := atomic.LoadInt64(&s.Chat.activity.read), atomic.LoadInt64(&s.Chat.activity.written)
+	handled := func() bool {
+		return s.Chat.activity.settled(read+int64(result.Packets)) && answered(order, atomic.LoadInt64(&s.Chat.activity.written)-written)
+	}
+	for _, record := range records {
+		if record.Direction != utils.InboundPacket {
+			continue
+		}
+		p := peers[record.Peer]
+		packet := replayIDReg.ReplaceAllFunc(record.Packet, func(match []byte) []byte {
+			id := replayIDReg.FindSubmatch(match)[1]
+			if replayed, ok := ids.Load(string(id)); ok {
+				return bytes.Replace(match, id, []byte(replayed.(string)), 1)
+			}
+			return match
+		})
+		if command := packetCommand(packet); command == utils.DisconnectCommand {
+			if replayed, ok := ids.Load(strings.TrimPrefix(string(packet), command)); ok {
+				packet = []byte(command + replayed.(string))
+			}
+		}
+		if _, err := p.conn.Write(packet); err != nil {
+			closePeers(order, &wg)
+			return nil, fmt.Errorf("could not replay packet: %s", err)
+		}
+		result.Packets += 1
+		if !waitFor(timeout, handled) {
+			closePeers(order, &wg)
+			return nil, fmt.Errorf("packet %d %s was not handled within %s", result.Packets, packetCommand(packet), timeout)
+		}
+	}
+	closePeers(order, &wg)
+
+	result.State = s.Chat.StateSummary()
+	if captured != nil {
+		result.StateDiff = DiffLines(captured, result.State)
+	}
+	for _, p := range order {
+		for _, line := range DiffLines(p.captured, p.replayed) {
+			result.Diff = append(result.Diff, fmt.Sprintf("%s %s", p.name, line))
+		}
+	}
+	return result, nil
+}
+
+// waitFor polls condition until it holds or timeout passes.
+func waitFor(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
+// answered reports whether peers received the packets written to them, so ids they carry are known.
+func answered(peers []*replayPeer, written int64) bool {
+	var received int64
+	for _, p := range peers {
+		received += atomic.LoadInt64(&p.received)
+	}
+	return received >= written
+}
+
+func closePeers(peers []*replayPeer, wg *sync.WaitGroup) {
+	for _, p := range peers {
+		p.conn.Close()
+	}
+	wg.Wait()
+}
+
+// Listen records the commands sent to the peer and maps the ids they carry to the captured ones.
+func (p *replayPeer) Listen(ids *sync.Map) {
+	for {
+		packet, _, err := utils.ReadUDPConn(p.conn)
+		if err != nil {
+			return
+		}
+		command := packetCommand(packet)
+		p.mu.Lock()
+		p.replayed = append(p.replayed, command)
+		var captured []byte
+		if expected := p.expected[command]; len(expected) != 0 {
+			captured, p.expected[command] = expected[0], expected[1:]
+		}
+		p.mu.Unlock()
+		p.mapIDs(ids, captured, packet)
+		atomic.AddInt64(&p.received, 1)
+	}
+}
+
+// mapIDs maps the ids of a captured packet to the ones of the packet received in its place.
+func (p *replayPeer) mapIDs(ids *sync.Map, captured []byte, packet []byte) {
+	if captured == nil {
+		return
+	}
+	capturedIDs, replayedIDs := replayIDReg.FindAllSubmatch(captured, -1), replayIDReg.FindAllSubmatch(packet, -1)
+	if len(capturedIDs) != len(replayedIDs) {
+		return
+	}
+	for i := range capturedIDs {
+		ids.LoadOrStore(string(capturedIDs[i][1]), string(replayedIDs[i][1]))
+	}
+}
+
+// packetCommand returns the command a packet starts with.
+func packetCommand(packet []byte) string {
+	if i := bytes.IndexByte(packet, '>'); i != -1 {
+		return string(packet[:i+1])
+	}
+	return UnknownCommand
+}
+
+// RecordState records the chat state in the capture. The lock is held until it is written
+// so the last state recorded is the latest.
+func (chat *Chat) RecordState() {
+	if chat.Capture == nil {
+		return
+	}
+	chat.mu.RLock()
+	defer chat.mu.RUnlock()
+	chat.Capture.RecordState(chat.stateSummary())
+}
+
+// StateSummary describes users, roles and history without generated ids or times, so states of different runs compare.
+func (chat *Chat) StateSummary() []string {
+	chat.mu.RLock()
+	defer chat.mu.RUnlock()
+	return chat.stateSummary()
+}
+
+func (chat *Chat) stateSummary() []string {
+	names := chat.ClientNames()
+	users := make([]string, 0, len(chat.Clients))
+	for _, client := range chat.Clients {
+		users = append(users, fmt.Sprintf("user %s role=%s online=%t muted=%t", client.Name, client.Role, client.Online, client.Muted))
+	}
+	sort.Strings(users)
+	state := append(users, fmt.Sprintf("history %d messages", len(chat.History)))
+	for _, message := range chat.History {
+		line := fmt.Sprintf("message %s: %q", names[message.AuthorID], message.Content)
+		if len(message.Reactions) != 0 {
+			emojis := make([]string, 0, len(message.Reactions))
+			for emoji, reactors := range message.Reactions {
+				emojis = append(emojis, fmt.Sprintf("%s%d", emoji, len(reactors)))
+			}
+			sort.Strings(emojis)
+			line += " " + strings.Join(emojis, " ")
+		}
+		state = append(state, line)
+	}
+	return state
+}
+
+// DiffLines returns the lines removed from a prefixed with "-" and added in b prefixed with "+".
+func DiffLines(a []string, b []string) []string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	diff := make([]string, 0)
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i, j = i+1, j+1
+		case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+			diff = append(diff, "+ "+b[j])
+			j++
+		default:
+			diff = append(diff, "- "+a[i])
+			i++
+		}
+	}
+	return diff
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"bytes"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestReplay(t *testing.T) {
+	s := NewTestServer(t)
+	output := &lockedBuffer{}
+	s.Capture = utils.NewCapture(output)
+	RunTestServer(t, s)
+	address := s.Addr().String()
+	aliceConn := CreateTestConnection(t, address)
+	defer aliceConn.Close()
+	bobConn := CreateTestConnection(t, address)
+	defer bobConn.Close()
+
+	alice := AddTestClient(t, aliceConn, &LoginInput{Username: "alice"})
+	first := SendTestMessage(t, aliceConn, &Message{Content: "first", AuthorID: alice.AssignedId})
+	second := SendTestMessage(t, aliceConn, &Message{Content: "second", AuthorID: alice.AssignedId})
+	bob := AddTestClient(t, bobConn, &LoginInput{Username: "bob"})
+	ReadTestCommand(t, aliceConn, utils.PresenceCommand)
+	reaction := &ReactionInput{ClientID: bob.AssignedId, MessageID: first.ID, Emoji: ":thumbsup:"}
+	if err := utils.WriteToUDPConn(bobConn, utils.AddReactionCommand, reaction); err != nil {
+		t.Fatal("could not write to UDP connection: ", err)
+	}
+	ReadTestCommand(t, aliceConn, utils.AddReactionCommand)
+	if err := utils.WriteToUDPConn(aliceConn, utils.DeleteMessageCommand, second); err != nil {
+		t.Fatal("could not write to UDP connection: ", err)
+	}
+	ReadTestCommand(t, bobConn, utils.DeleteMessageCommand)
+	DisconnectTestClient(t, bobConn, bob.AssignedId)
+	time.Sleep(100 * time.Millisecond) // let the last packets be captured
+
+	output.mu.Lock()
+	records, err := utils.ReadCapture(bytes.NewReader(output.buf.Bytes()))
+	output.mu.Unlock()
+	if err != nil {
+		t.Fatal("could not read capture: ", err)
+	}
+	result, err := Replay(NewTestServer(t), records, DefaultReplayTimeout)
+	if err != nil {
+		t.Fatal("replay failed: ", err)
+	}
+	assert.Equal(t, s.Chat.StateSummary(), result.State, "replay should rebuild the captured state")
+	assert.Contains(t, result.State, `message alice: "first" :thumbsup:1`)
+	assert.Contains(t, result.State, "user bob role=member online=false muted=false")
+	assert.Empty(t, result.StateDiff, "replay should end in the state recorded by the capture")
+	assert.Empty(t, result.Diff, "replay should answer like the captured server")
+	assert.False(t, result.Diverges())
+
+	t.Run("Diverging states are reported", func(t *testing.T) {
+		records := append(records, &utils.CaptureRecord{Direction: utils.StateRecord, State: []string{"history 0 messages"}})
+		result, err := Replay(NewTestServer(t), records, DefaultReplayTimeout)
+		if err != nil {
+			t.Fatal("replay failed: ", err)
+		}
+		assert.Contains(t, result.StateDiff, "- history 0 messages")
+		assert.True(t, result.Diverges())
+	})
+}
+
+func TestDiffLines(t *testing.T) {
+	diff := DiffLines([]string{"a", "b", "c", "d"}, []string{"a", "c", "d", "e"})
+	assert.Equal(t, []string{"- b", "+ e"}, diff)
+	assert.Empty(t, DiffLines([]string{"a"}, []string{"a"}))
+}
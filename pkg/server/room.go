@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RetainUnlimited = "unlimited" // keep every message
+	RetainCount     = "count"     // keep the last N messages
+	RetainAge       = "age"       // keep messages younger than a duration
+
+	DefaultRoomName    = "general"
+	CompactionInterval = time.Minute
+)
+
+// Retention decides how long messages of a room are kept.
+type Retention struct {
+	Policy string        `json:"policy"`
+	Count  int           `json:"count,omitempty"`
+	MaxAge time.Duration `json:"max_age,omitempty"`
+}
+
+// Room holds the configuration of the chat room, a server hosts a single room so its retention
+// applies to the whole server history.
+type Room struct {
+	Name      string    `json:"name"`
+	Retention Retention `json:"retention"`
+	Ephemeral bool      `json:"ephemeral,omitempty"` // history is wiped when the last client leaves
+}
+
+func NewRoom(name string) *Room {
+	return &Room{Name: name, Retention: Retention{Policy: RetainUnlimited}}
+}
+
+// ParseRetention reads a policy written as "unlimited", "count:<n>" or "age:<duration>".
+func ParseRetention(value string) (Retention, error) {
+	parts := strings.SplitN(value, ":", 2)
+	policy, arg := parts[0], ""
+	if len(parts) == 2 {
+		arg = parts[1]
+	}
+	switch policy {
+	case RetainUnlimited, "":
+		return Retention{Policy: RetainUnlimited}, nil
+	case RetainCount:
+		count, err := strconv.Atoi(arg)
+		if err != nil || count < 1 {
+			return Retention{}, fmt.Errorf("invalid retention count \"%s\"", arg)
+		}
+		return Retention{Policy: RetainCount, Count: count}, nil
+	case RetainAge:
+		maxAge, err := time.ParseDuration(arg)
+		if err != nil || maxAge <= 0 {
+			return Retention{}, fmt.Errorf("invalid retention age \"%s\"", arg)
+		}
+		return Retention{Policy: RetainAge, MaxAge: maxAge}, nil
+	default:
+		return Retention{}, fmt.Errorf("unknown retention policy \"%s\"", policy)
+	}
+}
+
+func (r Retention) String() string {
+	switch r.Policy {
+	case RetainCount:
+		return fmt.Sprintf("%s:%d", RetainCount, r.Count)
+	case RetainAge:
+		return fmt.Sprintf("%s:%s", RetainAge, r.MaxAge)
+	default:
+		return RetainUnlimited
+	}
+}
+
+// Expired returns how many messages at the start of history fall outside the policy.
+func (r Retention) Expired(history []*Message, now time.Time) int {
+	switch r.Policy {
+	case RetainCount:
+		if len(history) > r.Count {
+			return len(history) - r.Count
+		}
+	case RetainAge:
+		cutoff := now.Add(-r.MaxAge)
+		for i, message := range history {
+			if message.CreatedAt.After(cutoff) {
+				return i
+			}
+		}
+		return len(history)
+	}
+	return 0
+}
+
+// Compact removes messages the room retention policy no longer keeps and returns how many were removed.
+func (chat *Chat) Compact() (int, error) {
+	chat.mu.Lock()
+	defer chat.mu.Unlock()
+	expired := chat.Room.Retention.Expired(chat.History, time.Now())
+	if expired == 0 {
+		return 0, nil
+	}
+	ctx := context.Background()
+	ids := make([]string, expired)
+	for i, message := range chat.History[:expired] {
+		ids[i] = message.ID
+	}
+	epoch := time.Now() // compacted messages leave no tombstones, caches holding them are resent in full
+	stale := chat.StaleTombstones(epoch)
+	pipe := chat.RedisClient.TxPipeline()
+	pipe.LTrim(ctx, utils.RedisHistoryKey, int64(expired), -1)
+	pipe.HDel(ctx, utils.RedisMessagesKey, ids...)
+	if len(stale) != 0 {
+		pipe.HDel(ctx, utils.RedisDeletionsKey, stale...)
+	}
+	pipe.Set(ctx, utils.RedisEpochKey, epoch, 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to compact redis history: %s", err)
+	}
+	chat.Epoch = epoch
+	for _, id := range stale {
+		delete(chat.Deletions, id)
+	}
+	for _, message := range chat.History[:expired] {
+		chat.Index.Remove(message)
+	}
+	history := make([]*Message, len(chat.History)-expired)
+	copy(history, chat.History[expired:])
+	chat.History = history
+	return expired, nil
+}
+
+// RunCompaction enforces the room retention policy periodically.
+func (chat *Chat) RunCompaction(interval time.Duration) {
+	if chat.Room.Retention.Policy == RetainUnlimited {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		removed, err := chat.Compact()
+		if err != nil {
+			chat.Logger.Error("failed to compact history", "error", err)
+			continue
+		}
+		if removed != 0 {
+			chat.Logger.Info("compacted history", "removed", removed)
+		}
+	}
+}
+
+// WipeEphemeral clears the history of an ephemeral room once nobody is connected, along with the
+// deletions, read cursors and synced history pointing at it. Callers must hold the chat lock.
+func (chat *Chat) WipeEphemeral() {
+	if !chat.Room.Ephemeral || chat.connected != 0 {
+		return
+	}
+	ctx := context.Background()
+	epoch := time.Now()
+	pipe := chat.RedisClient.TxPipeline()
+	pipe.Del(ctx, utils.RedisHistoryKey, utils.RedisMessagesKey, utils.RedisDeletionsKey, utils.RedisReadCursorsKey)
+	pipe.Set(ctx, utils.RedisEpochKey, epoch, 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		chat.Logger.Error("failed to wipe ephemeral history", "error", err)
+		return
+	}
+	chat.Epoch = epoch
+	chat.History = make([]*Message, 0)
+	chat.Index = NewSearchIndex(nil)
+	chat.Deletions = map[string]*Tombstone{}
+	chat.ReadCursors = map[string]string{}
+	chat.syncs = map[string][]string{}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected Retention
+		fails    bool
+	}{
+		{value: "unlimited", expected: Retention{Policy: RetainUnlimited}},
+		{value: "count:500", expected: Retention{Policy: RetainCount, Count: 500}},
+		{value: "age:72h0m0s", expected: Retention{Policy: RetainAge, MaxAge: 72 * time.Hour}},
+		{value: "count:0", fails: true},
+		{value: "age:forever", fails: true},
+		{value: "forever", fails: true},
+	}
+	for _, test := range tests {
+		retention, err := ParseRetention(test.value)
+		if test.fails {
+			assert.Error(t, err, test.value)
+			continue
+		}
+		assert.NoError(t, err, test.value)
+		assert.Equal(t, test.expected, retention)
+		assert.Equal(t, test.value, retention.String())
+	}
+}
+
+func TestRetention_Expired(t *testing.T) {
+	now := time.Now()
+	history := []*Message{
+		{ID: "old", CreatedAt: now.Add(-3 * time.Hour)},
+		{ID: "recent", CreatedAt: now.Add(-2 * time.Hour)},
+		{ID: "new", CreatedAt: now.Add(-time.Minute)},
+	}
+	assert.Equal(t, 0, Retention{Policy: RetainUnlimited}.Expired(history, now))
+	assert.Equal(t, 1, Retention{Policy: RetainCount, Count: 2}.Expired(history, now))
+	assert.Equal(t, 0, Retention{Policy: RetainCount, Count: 5}.Expired(history, now))
+	assert.Equal(t, 2, Retention{Policy: RetainAge, MaxAge: time.Hour}.Expired(history, now))
+	assert.Equal(t, 3, Retention{Policy: RetainAge, MaxAge: time.Second}.Expired(history, now))
+}
+
+func TestChat_Retention(t *testing.T) {
+	t.Run("Compaction keeps the messages allowed by the room policy", func(t *testing.T) {
+		room := NewRoom("general")
+		room.Retention = Retention{Policy: RetainCount, Count: 2}
+		s := StartTestRoomServer(t, room)
+		conn := CreateTestConnection(t, s.Addr().String())
+		defer conn.Close()
+		client := AddTestClient(t, conn, &LoginInput{Username: "alice"})
+		var last *Message
+		for _, content := range []string{"one", "two", "three"} {
+			last = SendTestMessage(t, conn, &Message{Content: content, AuthorID: client.AssignedId})
+		}
+		bobConn := CreateTestConnection(t, s.Addr().String())
+		defer bobConn.Close()
+		bob := AddTestClient(t, bobConn, &LoginInput{Username: "bob"})
+		DisconnectTestClient(t, bobConn, bob.AssignedId)
+		unlock := s.Chat.lock()
+		assert.NoError(t, s.Chat.SaveTombstone(&Tombstone{ID: "deleted", DeletedAt: time.Now()}))
+		unlock()
+
+		removed, err := s.Chat.Compact()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, removed)
+		assert.Empty(t, FetchDeletionsFromRedis(s.RedisClient, s.Chat.Logger), "tombstones older than the epoch should be pruned")
+		history := FetchHistoryFromRedis(s.RedisClient, s.Chat.Logger)
+		assert.Len(t, history, 2)
+		assert.Equal(t, "two", history[0].Content)
+		unlock = s.Chat.rlock()
+		assert.Equal(t, "two", s.Chat.History[0].Content)
+		assert.Empty(t, s.Chat.Deletions)
+		assert.True(t, s.Chat.Epoch.Equal(FetchEpochFromRedis(s.RedisClient, s.Chat.Logger)))
+		unlock()
+
+		// bob cached "one" which left no tombstone, the full history replaces his cache
+		payload := AddTestClient(t, bobConn, &LoginInput{AssignedId: bob.AssignedId, LastMessageID: last.ID, CachedAt: bob.SyncedAt})
+		assert.False(t, payload.Delta)
+		assert.Equal(t, 2, payload.HistoryLength)
+	})
+
+	t.Run("History survives the room emptying unless it is ephemeral", func(t *testing.T) {
+		for _, ephemeral := range []bool{false, true} {
+			room := NewRoom("general")
+			room.Ephemeral = ephemeral
+			s := StartTestRoomServer(t, room)
+			conn := CreateTestConnection(t, s.Addr().String())
+			client := AddTestClient(t, conn, &LoginInput{Username: "alice"})
+			message := SendTestMessage(t, conn, &Message{Content: "hello", AuthorID: client.AssignedId})
+			unlock := s.Chat.lock()
+			assert.NoError(t, s.Chat.SaveTombstone(&Tombstone{ID: "deleted", DeletedAt: time.Now()}))
+			assert.NoError(t, s.Chat.SaveReadCursor(client.AssignedId, message.ID))
+			unlock()
+			DisconnectTestClient(t, conn, client.AssignedId)
+			conn.Close()
+
+			expected := 1
+			if ephemeral {
+				expected = 0
+			}
+			assert.Len(t, FetchHistoryFromRedis(s.RedisClient, s.Chat.Logger), expected)
+			assert.Len(t, FetchDeletionsFromRedis(s.RedisClient, s.Chat.Logger), expected)
+			assert.Len(t, FetchReadCursorsFromRedis(s.RedisClient, s.Chat.Logger), expected)
+			unlock = s.Chat.rlock()
+			assert.Len(t, s.Chat.Deletions, expected)
+			assert.Len(t, s.Chat.ReadCursors, expected)
+			unlock()
+		}
+	})
+}
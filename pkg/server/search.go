@@ -0,0 +1,201 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"net"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	SearchPageSize = 20
+	SearchDate     = "2006-01-02" // date layout of before: and after: filters
+)
+
+type SearchInput struct {
+	ClientID string `json:"client_id"`
+	Query    string `json:"query"`
+	Offset   int    `json:"offset,omitempty"` // results to skip, newest results first
+}
+
+type SearchResults struct {
+	Query    string     `json:"query"`
+	Offset   int        `json:"offset"`
+	Total    int        `json:"total"`
+	HasMore  bool       `json:"has_more"`
+	Messages []*Message `json:"messages"`
+	Error    string     `json:"error,omitempty"` // set when the query couldn't be parsed
+}
+
+// SearchQuery is a parsed search, every term and filter must match.
+type SearchQuery struct {
+	Terms  []string
+	From   string
+	Room   string
+	Before time.Time
+	After  time.Time
+}
+
+// SearchIndex maps words to the ids of messages containing them. Callers must hold the chat lock.
+type SearchIndex struct {
+	terms map[string]map[string]bool
+}
+
+func NewSearchIndex(history []*Message) *SearchIndex {
+	index := &SearchIndex{terms: map[string]map[string]bool{}}
+	for _, message := range history {
+		index.Add(message)
+	}
+	return index
+}
+
+// Tokenize splits text into lower cased words used as index terms.
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func (index *SearchIndex) Add(message *Message) {
+	for _, term := range Tokenize(message.Content) {
+		if index.terms[term] == nil {
+			index.terms[term] = map[string]bool{}
+		}
+		index.terms[term][message.ID] = true
+	}
+}
+
+func (index *SearchIndex) Remove(message *Message) {
+	for _, term := range Tokenize(message.Content) {
+		delete(index.terms[term], message.ID)
+		if len(index.terms[term]) == 0 {
+			delete(index.terms, term)
+		}
+	}
+}
+
+// Contains reports whether a message holds every term.
+func (index *SearchIndex) Contains(messageID string, terms []string) bool {
+	for _, term := range terms {
+		if !index.terms[term][messageID] {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseSearchQuery reads free text words mixed with from:name, before:date, after:date and in:#room filters.
+func ParseSearchQuery(query string) (*SearchQuery, error) {
+	parsed := &SearchQuery{}
+	for _, field := range strings.Fields(query) {
+		key, value := "", field
+		if i := strings.Index(field, ":"); i != -1 {
+			key, value = strings.ToLower(field[:i]), field[i+1:]
+		}
+		switch key {
+		case "from":
+			parsed.From = strings.TrimPrefix(value, "@")
+		case "in":
+			parsed.Room = strings.TrimPrefix(value, "#")
+		case "before", "after":
+			date, err := time.ParseInLocation(SearchDate, value, time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s date \"%s\", use YYYY-MM-DD", key, value)
+			}
+			if key == "before" {
+				parsed.Before = date
+			} else {
+				parsed.After = date.AddDate(0, 0, 1)
+			}
+		default:
+			parsed.Terms = append(parsed.Terms, Tokenize(field)...)
+		}
+	}
+	if len(parsed.Terms) == 0 && parsed.From == "" && parsed.Before.IsZero() && parsed.After.IsZero() {
+		return nil, fmt.Errorf("search query is empty")
+	}
+	return parsed, nil
+}
+
+// Search returns the messages matching query, newest first. Callers must hold the chat lock.
+func (chat *Chat) Search(query *SearchQuery) []*Message {
+	results := make([]*Message, 0)
+	if query.Room != "" && !strings.EqualFold(query.Room, chat.Room.Name) {
+		return results
+	}
+	authorID := ""
+	if query.From != "" {
+		author := chat.FindClientByName(query.From)
+		if author == nil {
+			return results
+		}
+		authorID = author.ID
+	}
+	for i := len(chat.History) - 1; i >= 0; i-- {
+		message := chat.History[i]
+		if authorID != "" && message.AuthorID != authorID {
+			continue
+		}
+		if !query.Before.IsZero() && !message.CreatedAt.Before(query.Before) {
+			continue
+		}
+		if !query.After.IsZero() && message.CreatedAt.Before(query.After) {
+			continue
+		}
+		if chat.Index.Contains(message.ID, query.Terms) {
+			results = append(results, message)
+		}
+	}
+	return results
+}
+
+// SendSearchResults answers a search request with one page of results, as many as fit in a packet.
+func (chat *Chat) SendSearchResults(data []byte, addr *net.UDPAddr) {
+	var input SearchInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		chat.Metrics.DecodeError()
+		chat.Logger.Warn("failed to unmarshal search input", "addr", addr.String(), "error", err)
+		chat.SendError(addr, utils.SearchCommand, InvalidRequestCode, "The request could not be decoded.")
+		return
+	}
+	unlock := chat.rlock()
+	defer unlock()
+	client, ok := chat.Clients[input.ClientID]
+	if !ok || !client.Online {
+		unlock()
+		chat.Logger.Warn("unrecognized client", "addr", addr.String(), "client_id", input.ClientID)
+		chat.SendError(addr, utils.SearchCommand, UnknownClientCode, "You are not connected, reconnect to continue.")
+		return
+	}
+	results := &SearchResults{Query: input.Query, Offset: input.Offset, Messages: make([]*Message, 0)}
+	query, err := ParseSearchQuery(input.Query)
+	if err != nil {
+		unlock()
+		results.Error = err.Error()
+		client.Send(utils.SearchCommand, results)
+		return
+	}
+	matches := chat.Search(query)
+	names := chat.ClientNames()
+	start := input.Offset
+	if start < 0 || start > len(matches) {
+		start = len(matches)
+	}
+	end := start + SearchPageSize
+	if end > len(matches) {
+		end = len(matches)
+	}
+	page := make([]*Message, 0, end-start)
+	for _, message := range matches[start:end] {
+		page = append(page, message.ForClient(client.ID, names))
+	}
+	unlock()
+	results.Total = len(matches)
+	page = page[:FitMessages(utils.SearchCommand, results, page)] // long messages shorten the page
+	results.Messages = page
+	results.HasMore = start+len(page) < len(matches)
+	client.Send(utils.SearchCommand, results)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// SearchTest sends a search request and returns the results received.
+func SearchTest(t *testing.T, conn *net.UDPConn, input *SearchInput) *SearchResults {
+	t.Helper()
+	if err := utils.WriteToUDPConn(conn, utils.SearchCommand, input); err != nil {
+		t.Fatal("could not write to UDP connection: ", err)
+	}
+	var results SearchResults
+	UnpackTestData(t, ReadTestCommand(t, conn, utils.SearchCommand), &results)
+	return &results
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	query, err := ParseSearchQuery("Deploy from:@bob in:#general before:2024-03-10 after:2024-03-01 friday!")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"deploy", "friday"}, query.Terms)
+	assert.Equal(t, "bob", query.From)
+	assert.Equal(t, "general", query.Room)
+	assert.Equal(t, time.Date(2024, 3, 10, 0, 0, 0, 0, time.Local), query.Before)
+	assert.Equal(t, time.Date(2024, 3, 2, 0, 0, 0, 0, time.Local), query.After)
+
+	_, err = ParseSearchQuery("before:yesterday")
+	assert.Error(t, err)
+	_, err = ParseSearchQuery("   ")
+	assert.Error(t, err)
+}
+
+func TestChat_Search(t *testing.T) {
+	s := StartTestServer(t)
+	address := s.Addr().String()
+	aliceConn := CreateTestConnection(t, address)
+	defer aliceConn.Close()
+	bobConn := CreateTestConnection(t, address)
+	defer bobConn.Close()
+	alice := AddTestClient(t, aliceConn, &LoginInput{Username: "alice"})
+	bob := AddTestClient(t, bobConn, &LoginInput{Username: "bob"})
+
+	SendTestMessage(t, aliceConn, &Message{Content: "Deploy is scheduled for friday", AuthorID: alice.AssignedId})
+	ReadTestCommand(t, bobConn, utils.AddMessageCommand)
+	deploy := SendTestMessage(t, bobConn, &Message{Content: "deploy went fine", AuthorID: bob.AssignedId})
+	SendTestMessage(t, bobConn, &Message{Content: "lunch?", AuthorID: bob.AssignedId})
+
+	t.Run("Every term has to match", func(t *testing.T) {
+		results := SearchTest(t, aliceConn, &SearchInput{ClientID: alice.AssignedId, Query: "deploy"})
+		assert.Equal(t, 2, results.Total)
+		assert.Equal(t, "deploy went fine", results.Messages[0].Content)
+		assert.Empty(t, results.Messages[0].AuthorID)
+		assert.Equal(t, "bob", results.Messages[0].AuthorName)
+
+		results = SearchTest(t, aliceConn, &SearchInput{ClientID: alice.AssignedId, Query: "deploy friday"})
+		assert.Equal(t, 1, results.Total)
+	})
+
+	t.Run("Filters narrow down results", func(t *testing.T) {
+		results := SearchTest(t, aliceConn, &SearchInput{ClientID: alice.AssignedId, Query: "from:bob"})
+		assert.Equal(t, 2, results.Total)
+		tomorrow := time.Now().AddDate(0, 0, 1).Format(SearchDate)
+		results = SearchTest(t, aliceConn, &SearchInput{ClientID: alice.AssignedId, Query: "deploy after:" + tomorrow})
+		assert.Equal(t, 0, results.Total)
+		results = SearchTest(t, aliceConn, &SearchInput{ClientID: alice.AssignedId, Query: "deploy in:#random"})
+		assert.Equal(t, 0, results.Total)
+	})
+
+	t.Run("Invalid queries return an error", func(t *testing.T) {
+		results := SearchTest(t, aliceConn, &SearchInput{ClientID: alice.AssignedId, Query: "before:soon"})
+		assert.NotEmpty(t, results.Error)
+	})
+
+	t.Run("Pages of long messages are shortened to fit in a packet", func(t *testing.T) {
+		long := strings.Repeat("<", MaxMessageLength-10) + " oversized" // escaped to 6 bytes each
+		for i := 0; i < SearchPageSize; i++ {
+			SendTestMessage(t, aliceConn, &Message{Content: long, AuthorID: alice.AssignedId})
+		}
+		seen := 0
+		for offset := 0; ; {
+			results := SearchTest(t, aliceConn, &SearchInput{ClientID: alice.AssignedId, Query: "oversized", Offset: offset})
+			assert.Equal(t, SearchPageSize, results.Total)
+			if !assert.NotEmpty(t, results.Messages) {
+				return
+			}
+			assert.Less(t, len(results.Messages), SearchPageSize)
+			seen += len(results.Messages)
+			offset += len(results.Messages)
+			if !results.HasMore {
+				break
+			}
+		}
+		assert.Equal(t, SearchPageSize, seen)
+	})
+
+	t.Run("Deleted messages are removed from the index", func(t *testing.T) {
+		if err := utils.WriteToUDPConn(bobConn, utils.DeleteMessageCommand, &Message{ID: deploy.ID, AuthorID: bob.AssignedId}); err != nil {
+			t.Fatal("could not write to UDP connection: ", err)
+		}
+		ReadTestCommand(t, aliceConn, utils.DeleteMessageCommand)
+		results := SearchTest(t, aliceConn, &SearchInput{ClientID: alice.AssignedId, Query: "went"})
+		assert.Equal(t, 0, results.Total)
+	})
+}
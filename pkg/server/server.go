@@ -2,7 +2,8 @@ package server
 
 import (
 	"github.com/go-redis/redis/v8"
-	"log"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"log/slog"
 	"net"
 )
 
@@ -10,21 +11,60 @@ type Server struct {
 	UDPAddr     *net.UDPAddr
 	conn        *net.UDPConn
 	RedisClient *redis.Client
+	Chat        *Chat
+	ControlPath string         // unix socket for admin commands, disabled when empty
+	Room        *Room          // room configuration, defaults to an unlimited "general" room
+	MetricsAddr string         // HTTP address serving /metrics and /healthz, disabled when empty
+	Logger      *slog.Logger   // defaults to slog.Default()
+	Capture     *utils.Capture // records every packet when set
 }
 
-func (s *Server) Run() error {
+// Listen binds the UDP connection and loads the chat state so packets can be received once Run is called.
+func (s *Server) Listen() error {
 	var err error
 	s.conn, err = net.ListenUDP("udp", s.UDPAddr)
 	if err != nil {
 		return err
 	}
-	chat := NewChat(s)
+	if s.Logger == nil {
+		s.Logger = slog.Default()
+	}
+	s.Chat = NewChat(s)
+	s.Chat.Logger.Info("server listening", "addr", s.conn.LocalAddr().String())
+	if s.ControlPath != "" {
+		if _, err := s.Chat.ListenControl(s.ControlPath); err != nil {
+			return err
+		}
+		s.Chat.Logger.Info("admin control socket listening", "path", s.ControlPath)
+	}
+	if s.MetricsAddr != "" {
+		listener, err := s.Chat.ServeMetrics(s.MetricsAddr)
+		if err != nil {
+			return err
+		}
+		s.Chat.Logger.Info("metrics listening", "addr", listener.Addr().String())
+	}
+	return nil
+}
 
-	log.Println("server listening on ", s.UDPAddr)
-	chat.Listen()
+func (s *Server) Run() error {
+	if s.conn == nil {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+	}
+	s.Chat.Listen()
 	return nil
 }
 
+// Addr returns the address the server is bound to.
+func (s *Server) Addr() net.Addr {
+	if s.conn == nil {
+		return s.UDPAddr
+	}
+	return s.conn.LocalAddr()
+}
+
 func NewServer(address string, redisClient *redis.Client) (*Server, error) {
 	udpAddr, err := net.ResolveUDPAddr("udp4", address)
 	if err != nil {
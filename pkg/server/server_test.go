@@ -34,6 +34,10 @@ func init() {
 		log.Println("error creating UDP server")
 		return
 	}
+	if err := server.Listen(); err != nil {
+		log.Println("error listening on UDP server: ", err)
+		return
+	}
 	go func() {
 		server.Run()
 	}()
@@ -86,12 +90,7 @@ func TestNetServer_Request(t *testing.T) {
 		if err := utils.WriteToUDPConn(conn, utils.AddMessageCommand, message); err != nil {
 			t.Error("could not write to UDP connection: ", err)
 		}
-		bytes, _, err := utils.ReadUDPConn(conn)
-		if err != nil {
-			t.Error("could not read from UDP connection: ", err)
-		}
-		command, data := utils.ParseCommandAndData(bytes)
-		assert.Equal(t, utils.AddMessageCommand, command)
+		data := ReadTestCommand(t, conn, utils.AddMessageCommand)
 		UnpackTestData(t, data, &receivedMessage)
 		assert.NotEmpty(t, receivedMessage.ID)
 		assert.Equal(t, initialPayload.AssignedId, receivedMessage.AuthorID)
@@ -124,12 +123,7 @@ func TestNetServer_Request(t *testing.T) {
 	})
 
 	t.Run(fmt.Sprintf("Adding a new client returns %d history logs with order", secondConHistory), func(t *testing.T) {
-		bytes, _, err := utils.ReadUDPConn(secondConn)
-		if err != nil {
-			t.Error("could not read from second UDP connection: ", err)
-		}
-		command, data := utils.ParseCommandAndData(bytes)
-		assert.Equal(t, utils.AddHistoryCommand, command)
+		data := ReadTestCommand(t, secondConn, utils.AddHistoryCommand)
 		var historyLog HistoryLog
 		UnpackTestData(t, data, &historyLog)
 		assert.Equal(t, 0, historyLog.Order)
@@ -139,12 +133,7 @@ func TestNetServer_Request(t *testing.T) {
 		if err := utils.WriteToUDPConn(conn, utils.DeleteMessageCommand, receivedMessage); err != nil {
 			t.Error("could not write to UDP connection: ", err)
 		}
-		bytes, _, err := utils.ReadUDPConn(conn)
-		if err != nil {
-			t.Error("could not read from second UDP connection: ", err)
-		}
-		command, data := utils.ParseCommandAndData(bytes)
-		assert.Equal(t, utils.DeleteMessageCommand, command)
+		data := ReadTestCommand(t, conn, utils.DeleteMessageCommand)
 		assert.Equal(t, receivedMessage.ID, string(data))
 
 		historyLength, err := server.RedisClient.LLen(ctx, utils.RedisHistoryKey).Result()
@@ -170,20 +159,75 @@ func TestNetServer_Request(t *testing.T) {
 			}
 		}
 	})
-	t.Run("Sending disconnect request for the last connected client clears history list in db", func(t *testing.T) {
+	t.Run("Sending disconnect request for the last connected client keeps history list in db", func(t *testing.T) {
+		SendTestMessage(t, secondConn, &Message{Content: "still here", AuthorID: secondConInitialPayload.AssignedId})
 		DisconnectTestClient(t, secondConn, secondConInitialPayload.AssignedId)
 
-		//	 make sure history list is deleted on redis
-		flushedHistoryLength, err := server.RedisClient.LLen(ctx, utils.RedisHistoryKey).Result()
+		//	 rooms are only wiped when ephemeral
+		historyLength, err := server.RedisClient.LLen(ctx, utils.RedisHistoryKey).Result()
 		if err != nil {
 			t.Error("failed to fetch history length")
 		}
-		assert.Equal(t, int64(0), flushedHistoryLength)
-
+		assert.Equal(t, int64(1), historyLength)
 	})
 }
 
-func CreateTestConnection(t *testing.T, address string) *net.UDPConn {
+// StartTestServer runs an isolated server with its own redis db on a random port.
+func StartTestServer(t *testing.T) *Server {
+	return StartTestRoomServer(t, nil)
+}
+
+// StartTestRoomServer starts an isolated server on a random port with the given room configuration.
+func StartTestRoomServer(t *testing.T, room *Room) *Server {
+	s := NewTestServer(t)
+	s.Room = room
+	RunTestServer(t, s)
+	return s
+}
+
+// NewTestServer creates a server backed by its own redis db on a random port, configure it before RunTestServer.
+func NewTestServer(t testing.TB) *Server {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal("error creating redis db: ", err)
+	}
+	t.Cleanup(mr.Close)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	s, err := NewServer("127.0.0.1:0", redisClient)
+	if err != nil {
+		t.Fatal("error creating UDP server: ", err)
+	}
+	return s
+}
+
+func RunTestServer(t testing.TB, s *Server) {
+	if err := s.Listen(); err != nil {
+		t.Fatal("error listening on UDP server: ", err)
+	}
+	go s.Run()
+}
+
+// ReadTestCommand reads packets from conn skipping other commands until command is received.
+func ReadTestCommand(t testing.TB, conn *net.UDPConn, command string) []byte {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		t.Fatal("could not set read deadline: ", err)
+	}
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		bytes, _, err := utils.ReadUDPConn(conn)
+		if err != nil {
+			t.Fatalf("did not receive \"%s\": %s", command, err)
+		}
+		cmd, data := utils.ParseCommandAndData(bytes)
+		if cmd == command {
+			return data
+		}
+	}
+}
+
+func CreateTestConnection(t testing.TB, address string) *net.UDPConn {
 	conn, err := utils.GetUDPConnection(address)
 	if err != nil {
 		t.Error("could not connect to server: ", err)
@@ -191,22 +235,17 @@ func CreateTestConnection(t *testing.T, address string) *net.UDPConn {
 	return conn
 }
 
-func UnpackTestData(t *testing.T, data []byte, target interface{}) {
+func UnpackTestData(t testing.TB, data []byte, target interface{}) {
 	if err := json.Unmarshal(data, target); err != nil {
 		t.Error("could not unmarshal messages list")
 	}
 }
 
-func AddTestClient(t *testing.T, conn *net.UDPConn, loginInput *LoginInput) *InitialPayload {
+func AddTestClient(t testing.TB, conn *net.UDPConn, loginInput *LoginInput) *InitialPayload {
 	if err := utils.WriteToUDPConn(conn, utils.ConnectCommand, loginInput); err != nil {
 		t.Error("could not write to UDP connection: ", err)
 	}
-	bytes, _, err := utils.ReadUDPConn(conn)
-	if err != nil {
-		t.Error("could not read from UDP connection: ", err)
-	}
-	command, data := utils.ParseCommandAndData(bytes)
-	assert.Equal(t, utils.InitialPayloadCommand, command)
+	data := ReadTestCommand(t, conn, utils.InitialPayloadCommand)
 	var initialPayload InitialPayload
 	UnpackTestData(t, data, &initialPayload)
 	return &initialPayload
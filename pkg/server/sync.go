@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// ResendHistoryInput asks again for initial history logs that never arrived.
+type ResendHistoryInput struct {
+	ClientID string `json:"client_id"`
+	Orders   []int  `json:"orders"`
+}
+
+// DeltaHistory returns what changed since a client cached history: older messages updated after the cache
+// was built followed by every newer message, and the ids of messages deleted since. ok is false when the
+// cache is unknown, too old to be patched or older than the epoch and the full history has to be sent.
+// Callers must hold the chat lock.
+func (chat *Chat) DeltaHistory(since *LoginInput) (history []*Message, deleted []string, ok bool) {
+	if since == nil || since.LastMessageID == "" || !since.CachedAt.After(chat.Epoch) {
+		return nil, nil, false
+	}
+	// the newest cached message has to belong to this history, even if it was deleted since
+	if _, tombstoned := chat.Deletions[since.LastMessageID]; !tombstoned && chat.MessageIndex(since.LastMessageID) == -1 {
+		return nil, nil, false
+	}
+	history = make([]*Message, 0)
+	newer := 0
+	for _, message := range chat.History {
+		switch {
+		case message.CreatedAt.After(since.CachedAt):
+			newer += 1
+		case !message.UpdatedAt.After(since.CachedAt):
+			continue
+		}
+		history = append(history, message)
+	}
+	if newer > UnreadLimit {
+		return nil, nil, false
+	}
+	deleted = make([]string, 0)
+	for id, tombstone := range chat.Deletions {
+		if tombstone.DeletedAt.After(since.CachedAt) {
+			deleted = append(deleted, id)
+		}
+	}
+	return history, deleted, true
+}
+
+func FetchEpochFromRedis(redisClient *redis.Client, logger *slog.Logger) time.Time {
+	epoch, err := redisClient.Get(context.Background(), utils.RedisEpochKey).Time()
+	if err != nil && err != redis.Nil {
+		logger.Error("could not fetch redis history epoch", "error", err)
+	}
+	return epoch
+}
+
+// ResendHistory sends the requested logs of the initial history again, logs of messages deleted since
+// are sent without a message so the client stops waiting for them.
+func (chat *Chat) ResendHistory(data []byte, addr *net.UDPAddr) {
+	var input ResendHistoryInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		chat.Metrics.DecodeError()
+		chat.Logger.Warn("failed to unmarshal resend history input", "addr", addr.String(), "error", err)
+		chat.SendError(addr, utils.ResendHistoryCommand, InvalidRequestCode, "The request could not be decoded.")
+		return
+	}
+	unlock := chat.rlock()
+	defer unlock()
+	client, ok := chat.Clients[input.ClientID]
+	if !ok || !client.Online {
+		unlock()
+		chat.Logger.Warn("unrecognized client", "addr", addr.String(), "client_id", input.ClientID)
+		chat.SendError(addr, utils.ResendHistoryCommand, UnknownClientCode, "You are not connected, reconnect to continue.")
+		return
+	}
+	synced := chat.syncs[client.ID]
+	names := chat.ClientNames()
+	logs := make([]*HistoryLog, 0, len(input.Orders))
+	for _, order := range input.Orders {
+		if order < 0 || order >= len(synced) {
+			continue
+		}
+		historyLog := &HistoryLog{Order: order}
+		if message := chat.FindMessage(synced[order]); message != nil {
+			historyLog.Message = message.ForClient(client.ID, names)
+		}
+		logs = append(logs, historyLog)
+	}
+	unlock()
+	chat.Logger.Debug("resending history logs", "addr", addr.String(), "client_id", client.ID, "count", len(logs))
+	chat.Metrics.Retransmitted(len(logs))
+	for i, historyLog := range logs {
+		if i != 0 && i%HistoryPageSize == 0 {
+			time.Sleep(HistoryPageWait)
+		}
+		client.Send(utils.AddHistoryCommand, historyLog)
+	}
+}
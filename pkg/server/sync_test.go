@@ -0,0 +1,102 @@
+package server
+
+import (
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestChat_DeltaSync(t *testing.T) {
+	s := StartTestServer(t)
+	address := s.Addr().String()
+	aliceConn := CreateTestConnection(t, address)
+	defer aliceConn.Close()
+	bobConn := CreateTestConnection(t, address)
+	defer bobConn.Close()
+
+	alice := AddTestClient(t, aliceConn, &LoginInput{Username: "alice"})
+	first := SendTestMessage(t, aliceConn, &Message{Content: "first", AuthorID: alice.AssignedId})
+	second := SendTestMessage(t, aliceConn, &Message{Content: "second", AuthorID: alice.AssignedId})
+	bob := AddTestClient(t, bobConn, &LoginInput{Username: "bob"})
+	DisconnectTestClient(t, bobConn, bob.AssignedId)
+
+	// changes made while bob is away
+	reaction := &ReactionInput{ClientID: alice.AssignedId, MessageID: first.ID, Emoji: ":thumbsup:"}
+	if err := utils.WriteToUDPConn(aliceConn, utils.AddReactionCommand, reaction); err != nil {
+		t.Fatal("could not write to UDP connection: ", err)
+	}
+	ReadTestCommand(t, aliceConn, utils.AddReactionCommand)
+	third := SendTestMessage(t, aliceConn, &Message{Content: "third", AuthorID: alice.AssignedId})
+	if err := utils.WriteToUDPConn(aliceConn, utils.DeleteMessageCommand, second); err != nil {
+		t.Fatal("could not write to UDP connection: ", err)
+	}
+	ReadTestCommand(t, aliceConn, utils.DeleteMessageCommand)
+
+	t.Run("Reconnecting with a cache only sends what changed", func(t *testing.T) {
+		payload := AddTestClient(t, bobConn, &LoginInput{AssignedId: bob.AssignedId, LastMessageID: second.ID, CachedAt: bob.SyncedAt})
+		assert.True(t, payload.Delta)
+		assert.Equal(t, []string{second.ID}, payload.Deleted)
+		assert.Equal(t, 2, payload.HistoryLength)
+		logs := make([]*Message, payload.HistoryLength)
+		for range logs {
+			var historyLog HistoryLog
+			UnpackTestData(t, ReadTestCommand(t, bobConn, utils.AddHistoryCommand), &historyLog)
+			logs[historyLog.Order] = historyLog.Message
+		}
+		assert.Equal(t, first.ID, logs[0].ID)
+		assert.Equal(t, []string{"alice"}, logs[0].Reactions[":thumbsup:"])
+		assert.Equal(t, third.ID, logs[1].ID)
+		DisconnectTestClient(t, bobConn, bob.AssignedId)
+	})
+
+	t.Run("Unknown cached messages get the full history", func(t *testing.T) {
+		payload := AddTestClient(t, bobConn, &LoginInput{AssignedId: bob.AssignedId, LastMessageID: "missing", CachedAt: bob.SyncedAt})
+		assert.False(t, payload.Delta)
+		assert.Equal(t, 2, payload.HistoryLength)
+	})
+}
+
+func TestChat_ResendHistory(t *testing.T) {
+	s := StartTestServer(t)
+	address := s.Addr().String()
+	aliceConn := CreateTestConnection(t, address)
+	defer aliceConn.Close()
+	alice := AddTestClient(t, aliceConn, &LoginInput{Username: "alice"})
+	SendTestMessage(t, aliceConn, &Message{Content: "first", AuthorID: alice.AssignedId})
+	second := SendTestMessage(t, aliceConn, &Message{Content: "second", AuthorID: alice.AssignedId})
+
+	bobConn := CreateTestConnection(t, address)
+	defer bobConn.Close()
+	bob := AddTestClient(t, bobConn, &LoginInput{Username: "bob"})
+	assert.Equal(t, 2, bob.HistoryLength)
+	for i := 0; i < bob.HistoryLength; i++ {
+		ReadTestCommand(t, bobConn, utils.AddHistoryCommand)
+	}
+
+	resend := func(orders ...int) *HistoryLog {
+		t.Helper()
+		input := &ResendHistoryInput{ClientID: bob.AssignedId, Orders: orders}
+		if err := utils.WriteToUDPConn(bobConn, utils.ResendHistoryCommand, input); err != nil {
+			t.Fatal("could not write to UDP connection: ", err)
+		}
+		var historyLog HistoryLog
+		UnpackTestData(t, ReadTestCommand(t, bobConn, utils.AddHistoryCommand), &historyLog)
+		return &historyLog
+	}
+
+	t.Run("Missing logs are resent by order", func(t *testing.T) {
+		historyLog := resend(1, 7)
+		assert.Equal(t, 1, historyLog.Order)
+		assert.Equal(t, second.ID, historyLog.Message.ID)
+	})
+
+	t.Run("Logs of deleted messages are resent empty", func(t *testing.T) {
+		if err := utils.WriteToUDPConn(aliceConn, utils.DeleteMessageCommand, second); err != nil {
+			t.Fatal("could not write to UDP connection: ", err)
+		}
+		ReadTestCommand(t, bobConn, utils.DeleteMessageCommand)
+		historyLog := resend(1)
+		assert.Equal(t, 1, historyLog.Order)
+		assert.Nil(t, historyLog.Message)
+	})
+}
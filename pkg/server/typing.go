@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"net"
+	"time"
+)
+
+type TypingInput struct {
+	ClientID string `json:"client_id"`
+	Typing   bool   `json:"typing"`
+}
+
+type TypingEvent struct {
+	Name   string `json:"name"`
+	Typing bool   `json:"typing"`
+}
+
+// Typing fans out typing indicators to other online clients, they are never persisted.
+func (chat *Chat) Typing(data []byte, addr *net.UDPAddr) {
+	var input TypingInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		chat.Metrics.DecodeError()
+		chat.Logger.Warn("failed to unmarshal typing input", "addr", addr.String(), "error", err)
+		chat.SendError(addr, utils.TypingCommand, InvalidRequestCode, "The request could not be decoded.")
+		return
+	}
+	unlock := chat.rlock()
+	defer unlock()
+	client, ok := chat.Clients[input.ClientID]
+	if !ok || !client.Online {
+		unlock()
+		chat.Logger.Warn("unrecognized client", "addr", addr.String(), "client_id", input.ClientID)
+		chat.SendError(addr, utils.TypingCommand, UnknownClientCode, "You are not connected, reconnect to continue.")
+		return
+	}
+	if client.IsMuted(time.Now()) {
+		unlock()
+		return
+	}
+	event := &TypingEvent{Name: client.Name, Typing: input.Typing}
+	unlock()
+	chat.BroadcastExcept(client.ID, utils.TypingCommand, event)
+}
+
+// BroadcastExcept sends a packet to all online clients but one.
+func (chat *Chat) BroadcastExcept(clientID string, command string, data interface{}) {
+	msg := utils.BuildUDPMessage(command, data)
+	if msg == nil {
+		return
+	}
+	unlock := chat.rlock()
+	defer unlock()
+	recipients := make([]*Client, 0, chat.connected)
+	for _, client := range chat.Clients {
+		if client.Online && client.ID != clientID {
+			recipients = append(recipients, client)
+		}
+	}
+	unlock()
+	for _, client := range recipients {
+		client.push(msg)
+	}
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"github.com/hirotachi/udp-cli-chat/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestChat_Typing(t *testing.T) {
+	s := StartTestServer(t)
+	address := s.Addr().String()
+
+	aliceConn := CreateTestConnection(t, address)
+	defer aliceConn.Close()
+	bobConn := CreateTestConnection(t, address)
+	defer bobConn.Close()
+
+	alice := AddTestClient(t, aliceConn, &LoginInput{Username: "alice"})
+	AddTestClient(t, bobConn, &LoginInput{Username: "bob"})
+
+	if err := utils.WriteToUDPConn(aliceConn, utils.TypingCommand, &TypingInput{ClientID: alice.AssignedId, Typing: true}); err != nil {
+		t.Error("could not write to UDP connection: ", err)
+	}
+	var event TypingEvent
+	UnpackTestData(t, ReadTestCommand(t, bobConn, utils.TypingCommand), &event)
+	assert.Equal(t, "alice", event.Name)
+	assert.True(t, event.Typing)
+}
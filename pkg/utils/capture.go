@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	InboundPacket  = "in"
+	OutboundPacket = "out"
+	StateRecord    = "state" // state of the recording side after handling packets, see RecordState
+)
+
+// CaptureRecord is a packet sent or received by one side of the protocol.
+type CaptureRecord struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	Peer      string    `json:"peer"`   // remote address of the packet
+	Packet    []byte    `json:"packet"` // raw packet, base64 in the capture file
+	State     []string  `json:"state,omitempty"`
+}
+
+// Capture writes packets as json lines, it is safe for concurrent use and a nil capture records nothing.
+type Capture struct {
+	mu      sync.Mutex
+	writer  *bufio.Writer
+	encoder *json.Encoder
+	closer  io.Closer
+	state   string // last recorded state
+}
+
+func NewCapture(w io.Writer) *Capture {
+	writer := bufio.NewWriter(w)
+	capture := &Capture{writer: writer, encoder: json.NewEncoder(writer)}
+	if closer, ok := w.(io.Closer); ok {
+		capture.closer = closer
+	}
+	return capture
+}
+
+// CreateCapture truncates or creates the capture file at path.
+func CreateCapture(path string) (*Capture, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not create capture file: %s", err)
+	}
+	return NewCapture(file), nil
+}
+
+// Record appends a packet exchanged with peer, records are flushed right away so a crash keeps them.
+func (c *Capture) Record(direction string, peer net.Addr, packet []byte) {
+	if c == nil {
+		return
+	}
+	record := &CaptureRecord{Time: time.Now(), Direction: direction, Packet: packet}
+	if peer != nil {
+		record.Peer = peer.String()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.encoder.Encode(record); err != nil {
+		return
+	}
+	c.writer.Flush()
+}
+
+// RecordState appends the state of the recording side when it changed since the last one recorded,
+// so a replay can be checked against the state the capture ended with.
+func (c *Capture) RecordState(state []string) {
+	if c == nil {
+		return
+	}
+	joined := strings.Join(state, "\n")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if joined == c.state {
+		return
+	}
+	c.state = joined
+	if err := c.encoder.Encode(&CaptureRecord{Time: time.Now(), Direction: StateRecord, State: state}); err != nil {
+		return
+	}
+	c.writer.Flush()
+}
+
+func (c *Capture) Close() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.writer.Flush(); err != nil {
+		return err
+	}
+	if c.closer != nil {
+		return c.closer.Close()
+	}
+	return nil
+}
+
+// ReadCapture reads all records of a capture file.
+func ReadCapture(r io.Reader) ([]*CaptureRecord, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), 4*MaxPacketSize)
+	records := make([]*CaptureRecord, 0)
+	for line := 1; scanner.Scan(); line++ {
+		var record CaptureRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("invalid capture record on line %d: %s", line, err)
+		}
+		records = append(records, &record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read capture: %s", err)
+	}
+	return records, nil
+}
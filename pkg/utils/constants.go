@@ -1,5 +1,8 @@
 package utils
 
+// MaxPacketSize is the largest payload a UDP packet can carry.
+const MaxPacketSize = 65507
+
 const (
 	ConnectCommand        = "/connect>"
 	InitialPayloadCommand = "/initial_payload>"
@@ -7,7 +10,28 @@ const (
 	DeleteMessageCommand  = "/delete_message>"
 	AddHistoryCommand     = "/add_history>"
 	AddMessageCommand     = "/add_message>"
+	ModerateCommand       = "/moderate>"
+	NoticeCommand         = "/notice>"
+	KickedCommand         = "/kicked>"
+	PresenceCommand       = "/presence>"
+	AwayCommand           = "/away>"
+	WhoisCommand          = "/whois>"
+	NickCommand           = "/nick>"
+	TypingCommand         = "/typing>"
+	AddReactionCommand    = "/add_reaction>"
+	RemoveReactionCommand = "/remove_reaction>"
+	MentionsCommand       = "/mentions>"
+	MarkReadCommand       = "/mark_read>"
+	HistoryPageCommand    = "/history_page>"
+	SearchCommand         = "/search>"
+	ResendHistoryCommand  = "/resend_history>"
+	ErrorCommand          = "/error>"
 
-	RedisClientsSetKey = "clients_set"
-	RedisHistoryKey    = "history_key"
+	RedisClientsSetKey  = "clients_set"
+	RedisHistoryKey     = "history_key"  // message ids by order
+	RedisMessagesKey    = "messages_key" // messages by id
+	RedisBansKey        = "bans_key"
+	RedisReadCursorsKey = "read_cursors_key"
+	RedisDeletionsKey   = "deletions_key"
+	RedisEpochKey       = "history_epoch_key" // last time messages were removed without tombstones
 )
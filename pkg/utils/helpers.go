@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -19,24 +20,35 @@ func GetUDPConnection(address string) (*net.UDPConn, error) {
 
 // WriteToUDPConn marshals data and combines it with command and sends it to connection.
 func WriteToUDPConn(conn *net.UDPConn, command string, data interface{}) error {
+	msg, err := EncodeClientMessage(command, data)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(msg)
+	return err
+}
+
+// EncodeClientMessage combines command with marshaled data, disconnections carry the raw client id.
+func EncodeClientMessage(command string, data interface{}) ([]byte, error) {
 	var bytes []byte
 	var err error
-	switch command {
-	case DisconnectCommand:
-		bytes = []byte(data.(string))
+	switch id, ok := data.(string); {
+	case command == DisconnectCommand && ok:
+		bytes = []byte(id)
+	case command == DisconnectCommand:
+		return nil, fmt.Errorf("disconnection data should be a client id, got %T", data)
 	default:
 		bytes, err = json.Marshal(data)
 		if err != nil {
-			return fmt.Errorf("could not marshal data: %s", err)
+			return nil, fmt.Errorf("could not marshal data: %s", err)
 		}
 	}
-	_, err = conn.Write(append([]byte(command), bytes...))
-	return err
+	return append([]byte(command), bytes...), nil
 }
 
 // ReadUDPConn read from UDP connection
 func ReadUDPConn(conn *net.UDPConn) ([]byte, *net.UDPAddr, error) {
-	out := make([]byte, 1024)
+	out := make([]byte, MaxPacketSize)
 	n, addr, err := conn.ReadFromUDP(out)
 	if err != nil {
 		return nil, nil, err
@@ -44,13 +56,15 @@ func ReadUDPConn(conn *net.UDPConn) ([]byte, *net.UDPAddr, error) {
 	return out[:n], addr, nil
 }
 
-// ParseCommandAndData reads received bytes and split commands and data
+// ParseCommandAndData reads received bytes and split commands and data,
+// packets without a command separator return an empty command.
 func ParseCommandAndData(msg []byte) (string, []byte) {
-	str := string(msg)
-	split := strings.SplitAfter(str, ">")
-	command := strings.TrimSpace(split[0])
-	data := split[1]
-	return command, []byte(data)
+	i := bytes.IndexByte(msg, '>')
+	if i == -1 {
+		return "", nil
+	}
+	command := strings.TrimSpace(string(msg[:i+1]))
+	return command, append([]byte{}, msg[i+1:]...)
 }
 
 // BroadcastWithCommand sends marshaled data to passed in channel
@@ -65,9 +79,12 @@ func BroadcastWithCommand(channel chan []byte, command string, data interface{})
 func BuildUDPMessage(command string, data interface{}) []byte {
 	var bytes []byte
 	var err error
-	switch command {
-	case DeleteMessageCommand:
-		bytes = []byte(data.(string))
+	switch id, ok := data.(string); {
+	case command == DeleteMessageCommand && ok:
+		bytes = []byte(id)
+	case command == DeleteMessageCommand:
+		log.Printf("failed to build command %s: data should be a message id, got %T\n", command, data)
+		return nil
 	default:
 		bytes, err = json.Marshal(data)
 		if err != nil {
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func FuzzParseCommandAndData(f *testing.F) {
+	for _, seed := range []string{ConnectCommand + `{"username":"alice"}`, DisconnectCommand + "id", " /typing> {}", "/a>b>c", ">", ""} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		command, data := ParseCommandAndData(packet)
+		if command == "" {
+			return
+		}
+		if !strings.HasSuffix(command, ">") || strings.Count(command, ">") != 1 {
+			t.Fatalf("command %q should end with its only separator", command)
+		}
+		if !bytes.HasSuffix(packet, data) || strings.TrimSpace(string(packet[:len(packet)-len(data)])) != command {
+			t.Fatalf("packet %q was not split into %q and %q", packet, command, data)
+		}
+	})
+}
+
+func FuzzBuildUDPMessage(f *testing.F) {
+	for _, command := range []string{DeleteMessageCommand, AddMessageCommand, DisconnectCommand} {
+		f.Add(command, "cfrkmvbo6dnc73ab2eog")
+	}
+	f.Fuzz(func(t *testing.T, command string, id string) {
+		for _, data := range []interface{}{id, len(id), []byte(id), nil} {
+			BuildUDPMessage(command, data)
+			EncodeClientMessage(command, data)
+		}
+		if command != DeleteMessageCommand {
+			return
+		}
+		parsed, data := ParseCommandAndData(BuildUDPMessage(command, id))
+		if parsed != command || string(data) != id {
+			t.Fatalf("message id %q was not sent as is", id)
+		}
+	})
+}